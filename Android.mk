@@ -77,7 +77,7 @@ LOCAL_CPPFLAGS := $(IPTSD_CPPFLAGS)
 LOCAL_SRC_FILES := $(call all-cpp-files-under, src/apps/daemon)
 LOCAL_MODULE := iptsd
 LOCAL_MODULE_TAGS := optional
-LOCAL_SHARED_LIBRARIES := $(IPTSD_SHARED_LIBRARIES)
+LOCAL_SHARED_LIBRARIES := $(IPTSD_SHARED_LIBRARIES) liblog
 LOCAL_STATIC_LIBRARIES := $(IPTSD_STATIC_LIBRARIES)
 LOCAL_HEADER_LIBRARIES := $(IPTSD_HEADER_LIBRARIES)
 LOCAL_C_INCLUDES:= $(LOCAL_PATH)/src
@@ -1,6 +1,7 @@
 // SPDX-License-Identifier: GPL-2.0-or-later
 
 #include "calibrate.hpp"
+#include "stylus.hpp"
 
 #include <common/types.hpp>
 #include <core/linux/device-runner.hpp>
@@ -19,9 +20,31 @@
 namespace iptsd::apps::calibrate {
 namespace {
 
+/*!
+ * Runs a calibration application on a device until it is stopped.
+ *
+ * @tparam T The type of the calibration application.
+ * @param[in] path The hidraw device node of the touchscreen.
+ * @return The exit code of the application.
+ */
+template <class T>
+int run_calibration(const std::filesystem::path &path)
+{
+	// Create a calibration application that reads from a device.
+	core::linux::DeviceRunner<T> calibrate {path};
+
+	const auto _sigterm = core::linux::signal<SIGTERM>([&](int) { calibrate.stop(); });
+	const auto _sigint = core::linux::signal<SIGINT>([&](int) { calibrate.stop(); });
+
+	if (!calibrate.run())
+		return EXIT_FAILURE;
+
+	return 0;
+}
+
 int run(const int argc, const char **argv)
 {
-	CLI::App app {"Utility for measuring your finger size and calibrating iptsd."};
+	CLI::App app {"Utility for calibrating the finger size or the stylus position for iptsd."};
 
 	std::filesystem::path path {};
 	app.add_option("DEVICE", path)
@@ -29,18 +52,16 @@ int run(const int argc, const char **argv)
 		->type_name("FILE")
 		->required();
 
-	CLI11_PARSE(app, argc, argv);
+	bool stylus = false;
+	app.add_flag("--stylus", stylus)
+		->description("Calibrate the position of the stylus instead of the finger size.");
 
-	// Create a calibration application that reads from a device.
-	core::linux::DeviceRunner<Calibrate> calibrate {path};
-
-	const auto _sigterm = core::linux::signal<SIGTERM>([&](int) { calibrate.stop(); });
-	const auto _sigint = core::linux::signal<SIGINT>([&](int) { calibrate.stop(); });
+	CLI11_PARSE(app, argc, argv);
 
-	if (!calibrate.run())
-		return EXIT_FAILURE;
+	if (stylus)
+		return run_calibration<StylusCalibrate>(path);
 
-	return 0;
+	return run_calibration<Calibrate>(path);
 }
 
 } // namespace
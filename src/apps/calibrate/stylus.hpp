@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_CALIBRATE_STYLUS_HPP
+#define IPTSD_APPS_CALIBRATE_STYLUS_HPP
+
+#include <common/buildopts.hpp>
+#include <common/chrono.hpp>
+#include <common/types.hpp>
+#include <core/generic/application.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+#include <ipts/data.hpp>
+
+#include <fmt/format.h>
+#include <spdlog/spdlog.h>
+
+#include <array>
+#include <filesystem>
+#include <fstream>
+#include <optional>
+#include <string>
+#include <vector>
+
+namespace iptsd::apps::calibrate {
+
+/*
+ * Guides the user through touching the corners of the screen with the stylus,
+ * and calculates the offset and scale that map the touched positions onto the corners.
+ */
+class StylusCalibrate : public core::Application {
+private:
+	using clock = chrono::system_clock;
+
+	// The corners of the screen, in the order in which they have to be touched.
+	constexpr static std::array<const char *, 4> CORNERS = {
+		"top left",
+		"top right",
+		"bottom right",
+		"bottom left",
+	};
+
+private:
+	// The uncalibrated positions at which the corners were touched.
+	std::vector<Vector2<f64>> m_corners {};
+
+	// Whether the stylus was touching the screen in the last report.
+	bool m_contact = false;
+
+public:
+	StylusCalibrate(const core::Config &config,
+	                const core::DeviceInfo &info,
+	                const std::optional<const ipts::Metadata> &metadata)
+		: core::Application(config, info, metadata) {};
+
+	void on_start() override
+	{
+		spdlog::info("Touch the corners of the screen, as exactly as possible.");
+		this->prompt();
+	}
+
+	void on_stylus(const ipts::StylusData &data) override
+	{
+		const bool touched = data.proximity && data.contact && !m_contact;
+		m_contact = data.proximity && data.contact;
+
+		if (!touched)
+			return;
+
+		// Undo the current calibration, so that the result doesn't depend on it.
+		const core::StylusCalibration &cal = m_config.calibration(data.serial);
+
+		const f64 x = (data.x - cal.offset_x / m_config.output_width()) / cal.scale_x;
+		const f64 y = (data.y - cal.offset_y / m_config.output_height()) / cal.scale_y;
+
+		spdlog::info("Stylus {:08X} touched at X: {:.4f}, Y: {:.4f} (calibrated X: {:.4f}, "
+		             "Y: {:.4f})",
+		             data.serial,
+		             x,
+		             y,
+		             data.x,
+		             data.y);
+
+		m_corners.emplace_back(x, y);
+
+		if (m_corners.size() < CORNERS.size()) {
+			this->prompt();
+			return;
+		}
+
+		this->finish(data.serial);
+
+		m_corners.clear();
+
+		spdlog::info("");
+		spdlog::info("Touch the corners again to repeat the calibration, or press Ctrl-C.");
+		this->prompt();
+	}
+
+private:
+	/*!
+	 * Tells the user which corner to touch next.
+	 */
+	void prompt() const
+	{
+		spdlog::info("Touch the {} corner of the screen", CORNERS.at(m_corners.size()));
+	}
+
+	/*!
+	 * Calculates the calibration from the touched corners and writes it to a config snippet.
+	 *
+	 * @param[in] serial The serial number of the calibrated stylus.
+	 */
+	void finish(const u32 serial) const
+	{
+		const f64 left = (m_corners[0].x() + m_corners[3].x()) / 2;
+		const f64 right = (m_corners[1].x() + m_corners[2].x()) / 2;
+		const f64 top = (m_corners[0].y() + m_corners[1].y()) / 2;
+		const f64 bottom = (m_corners[2].y() + m_corners[3].y()) / 2;
+
+		if (right <= left || bottom <= top) {
+			spdlog::error("The corners were not touched in the right order!");
+			return;
+		}
+
+		core::StylusCalibration cal {};
+		cal.scale_x = 1.0 / (right - left);
+		cal.scale_y = 1.0 / (bottom - top);
+
+		// The offset is applied after scaling, in centimeters.
+		cal.offset_x = -left * cal.scale_x * m_config.output_width();
+		cal.offset_y = -top * cal.scale_y * m_config.output_height();
+
+		const clock::duration now = clock::now().time_since_epoch();
+		usize unix = chrono::duration_cast<seconds<usize>>(now).count();
+
+		const std::string file = fmt::format("iptsd_calib_stylus_{}.conf", unix);
+		StylusCalibrate::write_file(file, serial, cal);
+
+		// clang-format off
+
+		spdlog::info("");
+		spdlog::info("OffsetX = {:.3f}", cal.offset_x);
+		spdlog::info("OffsetY = {:.3f}", cal.offset_y);
+		spdlog::info("ScaleX = {:.4f}", cal.scale_x);
+		spdlog::info("ScaleY = {:.4f}", cal.scale_y);
+		spdlog::info("");
+		spdlog::info("A config snippet with these values has been generated in the current directory.");
+		spdlog::info("Run the displayed command to install it, and restart iptsd.");
+		spdlog::info("    sudo cp {} {}/90-calibration-stylus.conf", file, common::buildopts::ConfigDir);
+
+		// clang-format on
+	}
+
+	static void write_file(const std::filesystem::path &out,
+	                       const u32 serial,
+	                       const core::StylusCalibration &cal)
+	{
+		std::ofstream writer {out};
+
+		writer << "#\n";
+		writer << "# Stylus: " << fmt::format("{:08X}", serial) << "\n";
+		writer << "#\n";
+		writer << "\n";
+		writer << "[Stylus]\n";
+		writer << "OffsetX = " << fmt::format("{:.3f}", cal.offset_x) << "\n";
+		writer << "OffsetY = " << fmt::format("{:.3f}", cal.offset_y) << "\n";
+		writer << "ScaleX = " << fmt::format("{:.4f}", cal.scale_x) << "\n";
+		writer << "ScaleY = " << fmt::format("{:.4f}", cal.scale_y) << "\n";
+
+		writer.close();
+	}
+};
+
+} // namespace iptsd::apps::calibrate
+
+#endif // IPTSD_APPS_CALIBRATE_STYLUS_HPP
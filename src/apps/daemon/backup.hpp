@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_BACKUP_HPP
+#define IPTSD_APPS_DAEMON_BACKUP_HPP
+
+#include <common/types.hpp>
+
+#include <filesystem>
+#include <string>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * How many older files are kept when a recording is rotated or started again.
+ */
+constexpr usize BACKUPS = 5;
+
+/*!
+ * Moves an existing file out of the way, so that a new one can be started in its place.
+ *
+ * The file gets the suffix ".1", and the older backups are moved up by one (".1" becomes ".2"
+ * and so on). Once there are @ref BACKUPS backups, the oldest one is removed.
+ *
+ * @param[in] path The file that is about to be created.
+ */
+inline void keep_backup(const std::filesystem::path &path)
+{
+	if (!std::filesystem::exists(path))
+		return;
+
+	const auto backup = [&](const usize generation) {
+		std::filesystem::path name = path;
+		name += "." + std::to_string(generation);
+
+		return name;
+	};
+
+	std::filesystem::remove(backup(BACKUPS));
+
+	for (usize i = BACKUPS - 1; i > 0; i--) {
+		if (std::filesystem::exists(backup(i)))
+			std::filesystem::rename(backup(i), backup(i + 1));
+	}
+
+	std::filesystem::rename(path, backup(1));
+}
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_BACKUP_HPP
@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_CAPTURE_HPP
+#define IPTSD_APPS_DAEMON_CAPTURE_HPP
+
+#include "backup.hpp"
+
+#include <common/casts.hpp>
+#include <common/types.hpp>
+#include <core/generic/device.hpp>
+#include <ipts/data.hpp>
+
+#include <gsl/gsl>
+
+#include <algorithm>
+#include <filesystem>
+#include <fstream>
+#include <iterator>
+#include <optional>
+#include <utility>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Records the raw data that is read from the device, in the format of iptsd-dump.
+ * The recorded files can be replayed by the daemon, or processed by the other tools.
+ *
+ * Every buffer is stored with a 64 bit header. The lower 32 bits are the size of the data,
+ * the upper 32 bits are the number of the source that sent it (0 for the main device).
+ * The data is padded with zeros to the buffer size of the main device, unless it is larger.
+ */
+class Capture {
+private:
+	// The file in which the data is saved.
+	std::filesystem::path m_path;
+
+	// How many bytes can be written to a file before it is rotated. 0 means no limit.
+	usize m_limit;
+
+	// Information about the device that produced the data.
+	core::DeviceInfo m_info;
+
+	// The IPTS device metadata, if the device has it.
+	std::optional<const ipts::Metadata> m_metadata;
+
+	std::ofstream m_writer {};
+
+	// How many bytes were written to the current file.
+	usize m_written = 0;
+
+public:
+	Capture(std::filesystem::path path,
+	        const usize limit,
+	        const core::DeviceInfo &info,
+	        const std::optional<const ipts::Metadata> &metadata)
+		: m_path {std::move(path)},
+		  m_limit {limit},
+		  m_info {info},
+		  m_metadata {metadata}
+	{
+		m_writer.exceptions(std::ios::badbit | std::ios::failbit);
+		this->open();
+	}
+
+	/*!
+	 * Writes a buffer of data to the capture file.
+	 *
+	 * @param[in] data The data that was read from the device.
+	 * @param[in] source The device that sent the data, 0 for the main device.
+	 */
+	void write(const gsl::span<u8> data, const usize source)
+	{
+		const u64 size = casts::to<u64>(data.size());
+		const u64 header = size | (casts::to<u64>(source) << 32);
+
+		// Sources have their own buffer size, which can be larger than the main one.
+		const u64 padded = std::max(size, m_info.buffer_size);
+		const usize record = sizeof(header) + casts::to<usize>(padded);
+
+		// Start a new file once the limit is reached.
+		if (m_limit > 0 && m_written + record > m_limit)
+			this->rotate();
+
+		// NOLINTNEXTLINE(cppcoreguidelines-pro-type-reinterpret-cast)
+		m_writer.write(reinterpret_cast<const char *>(&header), sizeof(header));
+
+		// NOLINTNEXTLINE(cppcoreguidelines-pro-type-reinterpret-cast)
+		m_writer.write(reinterpret_cast<char *>(data.data()),
+		               casts::to<std::streamsize>(size));
+
+		// Pad the data with zeros, so that we always write a full buffer.
+		std::fill_n(std::ostream_iterator<u8>(m_writer), padded - size, '\0');
+
+		m_written += record;
+	}
+
+private:
+	/*!
+	 * Opens the capture file and writes the header that describes the device.
+	 *
+	 * If the file already exists, it is kept as a backup, see @ref keep_backup.
+	 */
+	void open()
+	{
+		// Don't overwrite earlier captures, e.g. from before the device was reconnected.
+		keep_backup(m_path);
+
+		m_writer.open(m_path, std::ios::out | std::ios::binary);
+
+		// NOLINTNEXTLINE(cppcoreguidelines-pro-type-reinterpret-cast)
+		m_writer.write(reinterpret_cast<char *>(&m_info), sizeof(m_info));
+
+		const char has_meta = m_metadata.has_value() ? 1 : 0;
+		m_writer.write(&has_meta, sizeof(has_meta));
+
+		m_written = sizeof(m_info) + sizeof(has_meta);
+
+		if (m_metadata.has_value()) {
+			const ipts::Metadata m = m_metadata.value();
+
+			// NOLINTNEXTLINE(cppcoreguidelines-pro-type-reinterpret-cast)
+			m_writer.write(reinterpret_cast<const char *>(&m), sizeof(m));
+
+			m_written += sizeof(m);
+		}
+	}
+
+	/*!
+	 * Closes the current capture file and starts a new one.
+	 */
+	void rotate()
+	{
+		m_writer.close();
+		this->open();
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_CAPTURE_HPP
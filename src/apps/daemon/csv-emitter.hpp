@@ -0,0 +1,251 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_CSV_EMITTER_HPP
+#define IPTSD_APPS_DAEMON_CSV_EMITTER_HPP
+
+#include "backup.hpp"
+#include "emitter.hpp"
+
+#include <common/chrono.hpp>
+#include <common/types.hpp>
+
+#include <fmt/format.h>
+#include <spdlog/spdlog.h>
+
+#include <linux/input-event-codes.h>
+
+#include <exception>
+#include <filesystem>
+#include <fstream>
+#include <memory>
+#include <string>
+#include <utility>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Records the stylus events that are emitted to a CSV file, and passes them on to another emitter.
+ *
+ * Every SYN_REPORT writes one row with the current values of all recorded axes, which can be
+ * used for plotting the output of the daemon, e.g. for tuning smoothing or calibration.
+ */
+class CsvEmitter : public Emitter {
+private:
+	// The columns of the file. New columns must only be added at the end.
+	constexpr static const char *HEADER = "time,x,y,pressure,tilt_x,tilt_y,touch,rubber\n";
+
+private:
+	// The emitter that receives the events after they were recorded.
+	std::shared_ptr<Emitter> m_target;
+
+	// The file in which the events are saved.
+	std::filesystem::path m_path;
+
+	// How many bytes can be written to a file before it is rotated. 0 means no limit.
+	usize m_limit;
+
+	std::ofstream m_writer {};
+
+	// How many bytes were written to the current file.
+	usize m_written = 0;
+
+	// Whether writing to the file failed, and recording was stopped.
+	bool m_failed = false;
+
+	// When recording started. The time column counts the microseconds since then.
+	chrono::steady_clock::time_point m_start = chrono::steady_clock::now();
+
+	// The current values of the recorded axes.
+	i32 m_x = 0;
+	i32 m_y = 0;
+	i32 m_pressure = 0;
+	i32 m_tilt_x = 0;
+	i32 m_tilt_y = 0;
+	i32 m_touch = 0;
+	i32 m_rubber = 0;
+
+public:
+	CsvEmitter(std::filesystem::path path, const usize limit, std::shared_ptr<Emitter> target)
+		: m_target {std::move(target)},
+		  m_path {std::move(path)},
+		  m_limit {limit}
+	{
+		m_writer.exceptions(std::ios::badbit | std::ios::failbit);
+		this->open();
+	}
+
+	void set_name(std::string name) override
+	{
+		m_target->set_name(std::move(name));
+	}
+
+	void set_vendor(const u16 vendor) override
+	{
+		m_target->set_vendor(vendor);
+	}
+
+	void set_product(const u16 product) override
+	{
+		m_target->set_product(product);
+	}
+
+	void set_version(const u16 version) override
+	{
+		m_target->set_version(version);
+	}
+
+	void set_evbit(const i32 ev) const override
+	{
+		m_target->set_evbit(ev);
+	}
+
+	void set_propbit(const i32 prop) const override
+	{
+		m_target->set_propbit(prop);
+	}
+
+	void set_keybit(const i32 key) const override
+	{
+		m_target->set_keybit(key);
+	}
+
+	void set_relbit(const i32 rel) const override
+	{
+		m_target->set_relbit(rel);
+	}
+
+	void set_mscbit(const i32 msc) const override
+	{
+		m_target->set_mscbit(msc);
+	}
+
+	void set_absinfo(const u16 code, const i32 min, const i32 max, const i32 res) const override
+	{
+		m_target->set_absinfo(code, min, max, res);
+	}
+
+	void create() const override
+	{
+		m_target->create();
+	}
+
+	/*!
+	 * Records an event and passes it on.
+	 *
+	 * @param[in] type The event type.
+	 * @param[in] key The key of the button or axis.
+	 * @param[in] value The value of the button or axis.
+	 */
+	void emit(const u16 type, const u16 key, const i32 value) override
+	{
+		m_target->emit(type, key, value);
+
+		if (type == EV_ABS)
+			this->update_axis(key, value);
+
+		if (type == EV_KEY && key == BTN_TOUCH)
+			m_touch = value;
+
+		if (type == EV_KEY && key == BTN_TOOL_RUBBER)
+			m_rubber = value;
+
+		if (type == EV_SYN && key == SYN_REPORT)
+			this->write();
+	}
+
+private:
+	/*!
+	 * Saves the new value of a recorded axis.
+	 *
+	 * @param[in] code The axis that changed.
+	 * @param[in] value The new value of the axis.
+	 */
+	void update_axis(const u16 code, const i32 value)
+	{
+		switch (code) {
+		case ABS_X:
+			m_x = value;
+			break;
+		case ABS_Y:
+			m_y = value;
+			break;
+		case ABS_PRESSURE:
+			m_pressure = value;
+			break;
+		case ABS_TILT_X:
+			m_tilt_x = value;
+			break;
+		case ABS_TILT_Y:
+			m_tilt_y = value;
+			break;
+		default:
+			break;
+		}
+	}
+
+	/*!
+	 * Writes the current values of all axes as a new row.
+	 *
+	 * If writing fails (e.g. because the disk is full), recording stops, but the events
+	 * are still passed on.
+	 */
+	void write()
+	{
+		if (m_failed)
+			return;
+
+		const auto elapsed = chrono::steady_clock::now() - m_start;
+		const auto time = chrono::duration_cast<chrono::microseconds>(elapsed).count();
+
+		const std::string row = fmt::format("{},{},{},{},{},{},{},{}\n",
+		                                    time,
+		                                    m_x,
+		                                    m_y,
+		                                    m_pressure,
+		                                    m_tilt_x,
+		                                    m_tilt_y,
+		                                    m_touch,
+		                                    m_rubber);
+
+		try {
+			// Start a new file once the limit is reached.
+			if (m_limit > 0 && m_written + row.size() > m_limit)
+				this->rotate();
+
+			m_writer << row;
+			m_written += row.size();
+		} catch (const std::exception &e) {
+			spdlog::error("Failed to record stylus events, stopping: {}", e.what());
+			m_failed = true;
+		}
+	}
+
+	/*!
+	 * Opens the file and writes the header with the names of the columns.
+	 *
+	 * If the file already exists, it is kept as a backup, see @ref keep_backup.
+	 */
+	void open()
+	{
+		// Don't overwrite earlier recordings, e.g. from before the device was reconnected.
+		keep_backup(m_path);
+
+		m_writer.open(m_path, std::ios::out);
+		m_writer << HEADER;
+
+		m_written = std::char_traits<char>::length(HEADER);
+	}
+
+	/*!
+	 * Closes the current file and starts a new one.
+	 */
+	void rotate()
+	{
+		m_writer.close();
+		this->open();
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_CSV_EMITTER_HPP
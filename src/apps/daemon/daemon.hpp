@@ -3,8 +3,18 @@
 #ifndef IPTSD_APPS_DAEMON_DAEMON_HPP
 #define IPTSD_APPS_DAEMON_DAEMON_HPP
 
+#include "capture.hpp"
+#include "csv-emitter.hpp"
+#include "emitter.hpp"
+#include "keyboard.hpp"
+#include "log-emitter.hpp"
+#include "scroll.hpp"
+#include "singletouch.hpp"
+#include "socket-emitter.hpp"
 #include "stylus.hpp"
 #include "touch.hpp"
+#include "touchpad.hpp"
+#include "uinput-device.hpp"
 
 #include <common/types.hpp>
 #include <contacts/contact.hpp>
@@ -12,28 +22,86 @@
 #include <core/generic/config.hpp>
 #include <ipts/data.hpp>
 
+#include <gsl/gsl>
 #include <spdlog/spdlog.h>
 
+#include <cmath>
+#include <exception>
+#include <filesystem>
+#include <memory>
 #include <optional>
+#include <string>
+#include <utility>
 #include <vector>
 
 namespace iptsd::apps::daemon {
 
 class Daemon : public core::Application {
 private:
+	// Whether the input events are printed instead of being passed to the kernel.
+	bool m_dry_run;
+
+	// The unix socket that receives the input events instead of uinput, if not empty.
+	std::string m_socket;
+
 	// The touchscreen device.
 	TouchDevice m_touch;
 
 	// The stylus device.
 	StylusDevice m_stylus;
 
+	// The singletouch fallback device, if it is enabled.
+	std::optional<SingleTouchDevice> m_singletouch = std::nullopt;
+
+	// The keyboard for the eraser shortcut, if one is configured.
+	std::optional<KeyboardDevice> m_keyboard = std::nullopt;
+
+	// The device for scrolling with two fingers, if it is enabled.
+	std::optional<ScrollDevice> m_scroll = std::nullopt;
+
+	// The device that is controlled by the touchpad region, if it is enabled.
+	std::optional<TouchpadDevice> m_touchpad = std::nullopt;
+
+	// Whether the eraser was active the last time the stylus was in proximity.
+	bool m_rubber = false;
+
+	// Records the data that is read from the device, if enabled.
+	std::optional<Capture> m_capture = std::nullopt;
+
+	// The contacts that are not too close to the stylus.
+	std::vector<contacts::Contact<f64>> m_accepted {};
+
 public:
 	Daemon(const core::Config &config,
 	       const core::DeviceInfo &info,
-	       const std::optional<const ipts::Metadata> &metadata)
+	       const std::optional<const ipts::Metadata> &metadata,
+	       const std::filesystem::path &capture = {},
+	       const usize capture_limit = 0,
+	       const bool dry_run = false,
+	       std::string socket = {})
 		: core::Application(config, info, metadata),
-		  m_touch {config, info},
-		  m_stylus {config, info} {};
+		  m_dry_run {dry_run},
+		  m_socket {std::move(socket)},
+		  m_touch {config, info, this->emitter()},
+		  m_stylus {config, info, this->stylus_emitter()}
+	{
+		if (config.touch_singletouch)
+			m_singletouch.emplace(config, info, this->emitter());
+
+		if (config.touch_scroll)
+			m_scroll.emplace(config, info, this->emitter());
+
+		if (config.touch_touchpad || config.touch_mouse)
+			m_touchpad.emplace(config, info, this->emitter());
+
+		if (!config.stylus_rubber_keys.empty()) {
+			const std::vector<u16> &keys = config.stylus_rubber_keys;
+			m_keyboard.emplace(config, info, keys, this->emitter());
+		}
+
+		if (!capture.empty())
+			m_capture.emplace(capture, capture_limit, info, metadata);
+	}
 
 	void on_start() override
 	{
@@ -44,6 +112,101 @@ public:
 			spdlog::warn("Stylus is disabled!");
 	}
 
+	void on_stop() override
+	{
+		// Release all inputs, so that nothing is stuck once the devices are removed.
+		m_touch.disable();
+		m_stylus.disable();
+
+		if (m_singletouch.has_value())
+			m_singletouch->lift();
+	}
+
+	void on_data(const gsl::span<u8> data) override
+	{
+		if (m_capture.has_value()) {
+			try {
+				m_capture->write(data, m_source);
+			} catch (const std::exception &e) {
+				spdlog::error("Failed to capture data, stopping: {}", e.what());
+				m_capture.reset();
+			}
+		}
+
+		core::Application::on_data(data);
+
+		// Some devices keep sending other data after the stylus stopped sending reports.
+		m_stylus.check_pending();
+		m_stylus.check_timeout();
+		m_stylus.check_button();
+	}
+
+	void on_idle() override
+	{
+		m_stylus.check_pending();
+		m_stylus.check_timeout();
+		m_stylus.check_button();
+	}
+
+	void on_reopen() override
+	{
+		// Release all inputs, but keep the input devices, so that they don't disappear.
+		m_touch.disable();
+		m_stylus.disable();
+
+		if (!m_config.touch_disable)
+			m_touch.enable();
+
+		if (!m_config.stylus_disable)
+			m_stylus.enable();
+
+		if (m_touchpad.has_value())
+			m_touchpad->reset();
+
+		if (m_singletouch.has_value())
+			m_singletouch->lift();
+	}
+
+	void on_reload(const core::Config &previous) override
+	{
+		if (m_config.orientation != previous.orientation)
+			this->switch_orientation(previous);
+
+		m_touch.reload(m_config);
+		m_stylus.reload(m_config);
+
+		if (m_singletouch.has_value())
+			m_singletouch->reload(m_config);
+
+		if (m_scroll.has_value())
+			m_scroll->reload(m_config);
+
+		if (m_touchpad.has_value())
+			m_touchpad->reload(m_config);
+
+		// Contacts that are active while their input is switched off are lifted.
+		if (m_config.touch_disable && !previous.touch_disable) {
+			m_touch.disable();
+
+			if (m_touchpad.has_value())
+				m_touchpad->reset();
+
+			if (m_singletouch.has_value())
+				m_singletouch->lift();
+		}
+
+		if (m_config.stylus_disable != previous.stylus_disable) {
+			if (m_config.stylus_disable)
+				m_stylus.disable();
+			else
+				m_stylus.enable();
+		}
+
+		// The touchscreen might have been disabled by an option that is now turned off.
+		if (!m_config.touch_disable && !m_touch.enabled() && !m_stylus.active())
+			m_touch.enable();
+	}
+
 	void on_contacts(const std::vector<contacts::Contact<f64>> &contacts) override
 	{
 		if (m_config.touch_disable)
@@ -53,7 +216,32 @@ public:
 		if (m_config.touch_disable_on_stylus && !m_stylus.active() && !m_touch.enabled())
 			m_touch.enable();
 
-		m_touch.update(contacts);
+		const auto &filtered = this->reject_near_stylus(contacts);
+
+		// Fingers that touched down on the touchpad region only move the pointer.
+		const auto &accepted =
+			m_touchpad.has_value() ? m_touchpad->update(filtered) : filtered;
+
+		// Fingers that are scrolling are lifted from the touchscreen.
+		if (m_scroll.has_value() && m_scroll->update(accepted)) {
+			m_touch.update({});
+
+			if (m_singletouch.has_value())
+				m_singletouch->lift();
+
+			return;
+		}
+
+		m_touch.update(accepted);
+
+		if (!m_singletouch.has_value())
+			return;
+
+		// Don't emit taps for fingers or palms that are resting next to the stylus.
+		if (m_stylus.active())
+			m_singletouch->lift();
+		else
+			m_singletouch->update(accepted);
 	}
 
 	void on_stylus(const ipts::StylusData &stylus) override
@@ -65,6 +253,127 @@ public:
 			m_touch.disable();
 
 		m_stylus.update(stylus);
+
+		/*
+		 * Press the shortcut once whenever the eraser is switched on or off. This follows
+		 * the tool that the stylus device reports, so reports that are discarded while
+		 * warming up and a flickering eraser bit don't press it.
+		 */
+		if (m_keyboard.has_value() && m_stylus.active() && m_stylus.rubber() != m_rubber) {
+			m_rubber = m_stylus.rubber();
+			m_keyboard->press(m_config.stylus_rubber_keys);
+		}
+
+		if (m_singletouch.has_value() && m_stylus.active())
+			m_singletouch->lift();
+	}
+
+private:
+	/*!
+	 * Creates the destination for the events of a new input device.
+	 *
+	 * @return A logging emitter in dry run mode, a connection to the socket if one is
+	 *         configured, otherwise a new uinput device.
+	 */
+	[[nodiscard]] std::shared_ptr<Emitter> emitter() const
+	{
+		if (m_dry_run)
+			return std::make_shared<LogEmitter>();
+
+		if (!m_socket.empty())
+			return std::make_shared<SocketEmitter>(m_socket);
+
+		return std::make_shared<UinputDevice>();
+	}
+
+	/*!
+	 * Creates the destination for the events of the stylus.
+	 *
+	 * @return The emitter from @ref emitter, wrapped for recording the stylus if enabled.
+	 */
+	[[nodiscard]] std::shared_ptr<Emitter> stylus_emitter() const
+	{
+		std::shared_ptr<Emitter> emitter = this->emitter();
+
+		if (m_config.stylus_record.empty())
+			return emitter;
+
+		const usize limit = m_config.stylus_record_limit * 1024 * 1024;
+		return std::make_shared<CsvEmitter>(m_config.stylus_record, limit, emitter);
+	}
+
+	/*!
+	 * Lifts all inputs, and recreates the devices if the new orientation changed their axes.
+	 *
+	 * @param[in] previous The configuration of the previous orientation.
+	 */
+	void switch_orientation(const core::Config &previous)
+	{
+		spdlog::info("Switching to orientation {}", m_config.orientation);
+
+		const bool axes = m_config.swaps_axes() != previous.swaps_axes() ||
+		                  m_config.screen_width != previous.screen_width ||
+		                  m_config.screen_height != previous.screen_height;
+
+		if (axes) {
+			m_touch = TouchDevice {m_config, m_info, this->emitter()};
+			m_stylus = StylusDevice {m_config, m_info, this->stylus_emitter()};
+
+			if (m_singletouch.has_value())
+				m_singletouch.emplace(m_config, m_info, this->emitter());
+
+			return;
+		}
+
+		// Nothing should jump from where it was to where it is in the new orientation.
+		m_touch.disable();
+		m_touch.enable();
+
+		m_stylus.disable();
+		m_stylus.enable();
+
+		if (m_singletouch.has_value())
+			m_singletouch->lift();
+	}
+
+	/*!
+	 * Removes all contacts that are too close to the stylus while it is in proximity.
+	 *
+	 * @param[in] contacts All currently active contacts.
+	 * @return The contacts that should be passed on to the touchscreen.
+	 */
+	const std::vector<contacts::Contact<f64>> &
+	reject_near_stylus(const std::vector<contacts::Contact<f64>> &contacts)
+	{
+		const f64 radius = m_config.touch_disable_near_stylus;
+		const std::optional<Vector2<f64>> stylus = m_stylus.position();
+
+		if (radius <= 0 || !stylus.has_value())
+			return contacts;
+
+		// The center of the rejected area, in centimeters relative to the stylus.
+		Vector2<f64> center = Vector2<f64>::Zero();
+
+		// The palm of a right hand rests below and to the right of the stylus.
+		if (m_config.touch_handedness == "right")
+			center = Vector2<f64> {radius / 2, radius / 2};
+		else if (m_config.touch_handedness == "left")
+			center = Vector2<f64> {-radius / 2, radius / 2};
+
+		m_accepted.clear();
+
+		for (const contacts::Contact<f64> &contact : contacts) {
+			const f64 x = (contact.mean.x() - stylus->x()) * m_config.output_width();
+			const f64 y = (contact.mean.y() - stylus->y()) * m_config.output_height();
+
+			const f64 dx = x - center.x();
+			const f64 dy = y - center.y();
+
+			if (std::hypot(dx, dy) > radius)
+				m_accepted.push_back(contact);
+		}
+
+		return m_accepted;
 	}
 };
 
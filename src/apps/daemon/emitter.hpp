@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_EMITTER_HPP
+#define IPTSD_APPS_DAEMON_EMITTER_HPP
+
+#include <common/types.hpp>
+
+#include <string>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * The destination of the input events that are generated by the daemon.
+ *
+ * See @ref UinputDevice for the documentation of the individual functions.
+ */
+class Emitter {
+public:
+	virtual ~Emitter() = default;
+
+	virtual void set_name(std::string name) = 0;
+	virtual void set_vendor(u16 vendor) = 0;
+	virtual void set_product(u16 product) = 0;
+	virtual void set_version(u16 version) = 0;
+
+	virtual void set_evbit(i32 ev) const = 0;
+	virtual void set_propbit(i32 prop) const = 0;
+	virtual void set_keybit(i32 key) const = 0;
+	virtual void set_relbit(i32 rel) const = 0;
+	virtual void set_mscbit(i32 msc) const = 0;
+	virtual void set_absinfo(u16 code, i32 min, i32 max, i32 res) const = 0;
+
+	virtual void create() const = 0;
+	virtual void emit(u16 type, u16 key, i32 value) = 0;
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_EMITTER_HPP
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_KEYBOARD_HPP
+#define IPTSD_APPS_DAEMON_KEYBOARD_HPP
+
+#include "emitter.hpp"
+
+#include <common/types.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+
+#include <linux/input-event-codes.h>
+
+#include <memory>
+#include <utility>
+#include <vector>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * A keyboard for emitting shortcuts that are triggered by the stylus.
+ */
+class KeyboardDevice {
+private:
+	// Where the input events are sent to.
+	std::shared_ptr<Emitter> m_emitter;
+
+public:
+	KeyboardDevice(const core::Config &config,
+	               const core::DeviceInfo &info,
+	               const std::vector<u16> &keys,
+	               std::shared_ptr<Emitter> emitter)
+		: m_emitter {std::move(emitter)}
+	{
+		u16 vendor = info.vendor;
+		u16 product = info.product;
+
+		// Configured IDs override the ones that were inherited from the IPTS device.
+		if (config.uinput_vendor != 0)
+			vendor = config.uinput_vendor;
+
+		if (config.uinput_product != 0)
+			product = config.uinput_product;
+
+		m_emitter->set_name(config.uinput_keyboard_name);
+		m_emitter->set_vendor(vendor);
+		m_emitter->set_product(product);
+		m_emitter->set_version(config.uinput_version);
+
+		m_emitter->set_evbit(EV_KEY);
+
+		for (const u16 key : keys)
+			m_emitter->set_keybit(key);
+
+		m_emitter->create();
+	}
+
+	/*!
+	 * Presses a combination of keys, and releases them again.
+	 *
+	 * The keys are pressed in the given order, and released in reverse.
+	 *
+	 * @param[in] keys The keys to press.
+	 */
+	void press(const std::vector<u16> &keys) const
+	{
+		for (const u16 key : keys)
+			m_emitter->emit(EV_KEY, key, 1);
+
+		this->sync();
+
+		for (auto it = keys.crbegin(); it != keys.crend(); it++)
+			m_emitter->emit(EV_KEY, *it, 0);
+
+		this->sync();
+	}
+
+private:
+	/*!
+	 * Commits the emitted events to the linux kernel.
+	 */
+	void sync() const
+	{
+		m_emitter->emit(EV_SYN, SYN_REPORT, 0);
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_KEYBOARD_HPP
@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_LOG_EMITTER_HPP
+#define IPTSD_APPS_DAEMON_LOG_EMITTER_HPP
+
+#include "emitter.hpp"
+
+#include <common/types.hpp>
+
+#include <fmt/format.h>
+#include <spdlog/spdlog.h>
+
+#include <linux/input-event-codes.h>
+
+#include <string>
+#include <utility>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Prints the generated input events instead of passing them to the linux kernel.
+ * This allows testing the daemon on devices without access to uinput.
+ */
+class LogEmitter : public Emitter {
+private:
+	std::string m_name;
+	u16 m_vendor = 0;
+	u16 m_product = 0;
+
+	// The events that were emitted since the last SYN_REPORT.
+	std::string m_events {};
+
+public:
+	void set_name(std::string name) override
+	{
+		m_name = std::move(name);
+	}
+
+	void set_vendor(const u16 vendor) override
+	{
+		m_vendor = vendor;
+	}
+
+	void set_product(const u16 product) override
+	{
+		m_product = product;
+	}
+
+	void set_version(const u16 /* unused */) override {};
+	void set_evbit(const i32 /* unused */) const override {};
+	void set_propbit(const i32 /* unused */) const override {};
+	void set_keybit(const i32 /* unused */) const override {};
+	void set_relbit(const i32 /* unused */) const override {};
+	void set_mscbit(const i32 /* unused */) const override {};
+
+	void set_absinfo(const u16 code, const i32 min, const i32 max, const i32 res) const override
+	{
+		const std::string axis = LogEmitter::code_name(EV_ABS, code);
+		spdlog::debug("{}: {} from {} to {} ({} units/mm)", m_name, axis, min, max, res);
+	}
+
+	void create() const override
+	{
+		const u16 vendor = m_vendor;
+		const u16 product = m_product;
+
+		spdlog::info("{}: Created device {:04X}:{:04X}", m_name, vendor, product);
+	}
+
+	/*!
+	 * Prints an event.
+	 *
+	 * Events are collected until a SYN_REPORT is emitted, and then printed as one line.
+	 *
+	 * @param[in] type The event type.
+	 * @param[in] key The key of the button or axis.
+	 * @param[in] value The value of the button or axis.
+	 */
+	void emit(const u16 type, const u16 key, const i32 value) override
+	{
+		if (type != EV_SYN || key != SYN_REPORT) {
+			m_events += fmt::format(" {}={}", LogEmitter::code_name(type, key), value);
+			return;
+		}
+
+		spdlog::info("{}:{}", m_name, m_events.empty() ? " (empty)" : m_events);
+		m_events.clear();
+	}
+
+private:
+	/*!
+	 * The name of an event code, as it is defined in linux/input-event-codes.h.
+	 *
+	 * @param[in] type The event type.
+	 * @param[in] code The key of the button or axis.
+	 * @return The name of the event code, or its numeric value if it is unknown.
+	 */
+	[[nodiscard]] static std::string code_name(const u16 type, const u16 code)
+	{
+		if (type == EV_KEY) {
+			switch (code) {
+			case BTN_TOUCH:
+				return "BTN_TOUCH";
+			case BTN_TOOL_PEN:
+				return "BTN_TOOL_PEN";
+			case BTN_TOOL_RUBBER:
+				return "BTN_TOOL_RUBBER";
+			case BTN_STYLUS:
+				return "BTN_STYLUS";
+			case BTN_STYLUS2:
+				return "BTN_STYLUS2";
+			case BTN_LEFT:
+				return "BTN_LEFT";
+			default:
+				return fmt::format("KEY_{}", code);
+			}
+		}
+
+		if (type == EV_ABS) {
+			switch (code) {
+			case ABS_X:
+				return "ABS_X";
+			case ABS_Y:
+				return "ABS_Y";
+			case ABS_PRESSURE:
+				return "ABS_PRESSURE";
+			case ABS_DISTANCE:
+				return "ABS_DISTANCE";
+			case ABS_TILT_X:
+				return "ABS_TILT_X";
+			case ABS_TILT_Y:
+				return "ABS_TILT_Y";
+			case ABS_MISC:
+				return "ABS_MISC";
+			case ABS_MT_SLOT:
+				return "ABS_MT_SLOT";
+			case ABS_MT_TRACKING_ID:
+				return "ABS_MT_TRACKING_ID";
+			case ABS_MT_TOOL_TYPE:
+				return "ABS_MT_TOOL_TYPE";
+			case ABS_MT_POSITION_X:
+				return "ABS_MT_POSITION_X";
+			case ABS_MT_POSITION_Y:
+				return "ABS_MT_POSITION_Y";
+			case ABS_MT_ORIENTATION:
+				return "ABS_MT_ORIENTATION";
+			case ABS_MT_TOUCH_MAJOR:
+				return "ABS_MT_TOUCH_MAJOR";
+			case ABS_MT_TOUCH_MINOR:
+				return "ABS_MT_TOUCH_MINOR";
+			case ABS_MT_PRESSURE:
+				return "ABS_MT_PRESSURE";
+			case ABS_MT_DISTANCE:
+				return "ABS_MT_DISTANCE";
+			default:
+				return fmt::format("ABS_{}", code);
+			}
+		}
+
+		if (type == EV_REL) {
+			switch (code) {
+			case REL_WHEEL:
+				return "REL_WHEEL";
+			case REL_HWHEEL:
+				return "REL_HWHEEL";
+			default:
+				return fmt::format("REL_{}", code);
+			}
+		}
+
+		if (type == EV_MSC && code == MSC_SERIAL)
+			return "MSC_SERIAL";
+
+		if (type == EV_MSC && code == MSC_TIMESTAMP)
+			return "MSC_TIMESTAMP";
+
+		return fmt::format("{}:{}", type, code);
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_LOG_EMITTER_HPP
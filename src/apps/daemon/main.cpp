@@ -2,23 +2,245 @@
 
 #include "daemon.hpp"
 
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
 #include <common/types.hpp>
+#include <core/linux/config-loader.hpp>
 #include <core/linux/device-runner.hpp>
+#include <core/linux/file-runner.hpp>
+#include <core/linux/hidraw-device.hpp>
 #include <core/linux/signal-handler.hpp>
+#include <hid/report.hpp>
+#include <ipts/device.hpp>
 
 #include <CLI/CLI.hpp>
+#include <fmt/format.h>
 #include <gsl/gsl>
 #include <spdlog/spdlog.h>
 
+#ifdef __ANDROID__
+#include <spdlog/sinks/android_sink.h>
+#endif
+
+#include <algorithm>
 #include <csignal>
 #include <cstdlib>
 #include <exception>
 #include <filesystem>
+#include <memory>
+#include <optional>
 #include <string>
+#include <thread>
 
 namespace iptsd::apps::daemon {
 namespace {
 
+/*
+ * How long to wait before trying to reconnect to the device.
+ * The delay is doubled after every failed attempt, until it reaches the maximum.
+ */
+constexpr chrono::milliseconds RECONNECT_DELAY_MIN = 1s;
+constexpr chrono::milliseconds RECONNECT_DELAY_MAX = 30s;
+
+using Runner = core::linux::DeviceRunner<Daemon>;
+
+/*!
+ * Waits for a while, unless the daemon is stopped in the meantime.
+ *
+ * @param[in] duration How long to wait.
+ */
+void wait(const chrono::milliseconds duration)
+{
+	const auto until = chrono::steady_clock::now() + duration;
+
+	while (!Runner::stopping() && chrono::steady_clock::now() < until)
+		std::this_thread::sleep_for(100ms);
+}
+
+/*!
+ * The name of a type of HID report.
+ *
+ * @param[in] type The type of the report.
+ * @return The name of the type, for printing it.
+ */
+std::string report_type(const hid::ReportType type)
+{
+	switch (type) {
+	case hid::ReportType::Input:
+		return "Input";
+	case hid::ReportType::Output:
+		return "Output";
+	case hid::ReportType::Feature:
+		return "Feature";
+	default:
+		return "Unknown";
+	}
+}
+
+/*!
+ * Prints everything that is known about a device, without processing any data from it.
+ *
+ * This includes the reports from the HID descriptor, the IPTS metadata and the values that
+ * the configuration derives from them, which helps with adding support for new devices.
+ *
+ * @param[in] path The hidraw device node of the touchscreen.
+ * @return The exit code of the daemon.
+ */
+int run_probe(const std::filesystem::path &path)
+{
+	const auto hidraw = std::make_shared<core::linux::HidrawDevice>(path);
+	const ipts::Device device {hidraw};
+
+	core::DeviceInfo info {};
+	info.vendor = hidraw->vendor();
+	info.product = hidraw->product();
+	info.buffer_size = device.buffer_size();
+
+	const u16 vendor = info.vendor;
+	const u16 product = info.product;
+	const usize buffer_size = info.buffer_size;
+
+	spdlog::info("Device: {:04X}:{:04X}", vendor, product);
+	spdlog::info("Buffer size: {} bytes", buffer_size);
+
+	for (const hid::Report &report : hidraw->descriptor()) {
+		std::string usages {};
+
+		for (const hid::Usage &usage : report.usages())
+			usages += fmt::format(" {:#06x}:{:#04x}", usage.page, usage.value);
+
+		const std::string type = report_type(report.type());
+		const u8 id = report.id().value_or(0);
+		const u64 size = report.size() / 8;
+
+		spdlog::info("Report: {} {:#04x}, {} bytes, usages:{}", type, id, size, usages);
+	}
+
+	const std::optional<const ipts::Metadata> metadata = device.metadata();
+
+	if (metadata.has_value()) {
+		const auto &dim = metadata->dimensions;
+		const auto &t = metadata->transform;
+
+		const u32 rows = dim.rows;
+		const u32 columns = dim.columns;
+
+		const f64 width = casts::to<f64>(dim.width) / 100;
+		const f64 height = casts::to<f64>(dim.height) / 100;
+
+		const f32 xx = t.xx;
+		const f32 yx = t.yx;
+		const f32 tx = t.tx;
+		const f32 xy = t.xy;
+		const f32 yy = t.yy;
+		const f32 ty = t.ty;
+
+		spdlog::info("Metadata: Heatmap with {} rows and {} columns", rows, columns);
+		spdlog::info("Metadata: Size {:.2f} x {:.2f} mm", width, height);
+		spdlog::info("Metadata: Transform {} {} {} / {} {} {}", xx, yx, tx, xy, yy, ty);
+	} else {
+		spdlog::info("Metadata: Not supported by the device");
+	}
+
+	const core::linux::ConfigLoader loader {info, metadata};
+	const core::Config config = loader.config();
+
+	const u16 max_x = config.stylus_max_x;
+	const u16 max_y = config.stylus_max_y;
+
+	spdlog::info("Config: Size {:.2f} x {:.2f} cm", config.width, config.height);
+	spdlog::info("Config: InvertX = {}, InvertY = {}", config.invert_x, config.invert_y);
+	spdlog::info("Config: Stylus range 0 - {} / 0 - {}", max_x, max_y);
+
+	return 0;
+}
+
+/*!
+ * Replays touch data that was recorded by iptsd-dump, as if it was coming from a device.
+ *
+ * @param[in] path The file containing the recorded data.
+ * @param[in] dry_run Whether to print the input events instead of creating input devices.
+ * @param[in] socket The unix socket that receives the input events, if not empty.
+ * @return The exit code of the daemon.
+ */
+int run_replay(const std::filesystem::path &path, const bool dry_run, const std::string &socket)
+{
+	// Create a daemon application that reads from a file.
+	core::linux::FileRunner<Daemon> daemon {
+		path,
+		std::filesystem::path {},
+		usize {0},
+		dry_run,
+		socket,
+	};
+
+	const auto _sigterm = core::linux::signal<SIGTERM>([&](int) { daemon.stop(); });
+	const auto _sigint = core::linux::signal<SIGINT>([&](int) { daemon.stop(); });
+
+	daemon.run();
+	return 0;
+}
+
+/*!
+ * Translates the touch data of a device, and reconnects to it if the connection is lost.
+ *
+ * @param[in] path The hidraw device node of the touchscreen.
+ * @param[in] capture The file in which the data from the device is recorded, if not empty.
+ * @param[in] capture_limit The size in bytes after which the capture file is rotated.
+ * @param[in] dry_run Whether to print the input events instead of creating input devices.
+ * @param[in] socket The unix socket that receives the input events, if not empty.
+ * @return The exit code of the daemon.
+ */
+int run_device(const std::filesystem::path &path,
+               const std::filesystem::path &capture,
+               const usize capture_limit,
+               const bool dry_run,
+               const std::string &socket)
+{
+	// Create a daemon application that reads from a device.
+	std::optional<Runner> daemon {};
+	daemon.emplace(path, capture, capture_limit, dry_run, socket);
+
+	/*
+	 * The handlers only set flags that are shared by all runners. They must not access the
+	 * runner itself, because it is replaced while reconnecting.
+	 */
+	const auto _sigterm = core::linux::signal<SIGTERM>([](int) { Runner::stop(); });
+	const auto _sigint = core::linux::signal<SIGINT>([](int) { Runner::stop(); });
+	const auto _sighup = core::linux::signal<SIGHUP>([](int) { Runner::reload(); });
+	const auto _sigusr1 = core::linux::signal<SIGUSR1>([](int) { Runner::toggle_touch(); });
+	const auto _sigusr2 = core::linux::signal<SIGUSR2>([](int) { Runner::toggle_stylus(); });
+
+	chrono::milliseconds delay = RECONNECT_DELAY_MIN;
+
+	/*
+	 * If the connection to the device gets lost (e.g. because it was suspended or removed),
+	 * the uinput devices are torn down and the daemon tries to open the device again.
+	 */
+	while (!daemon->run()) {
+		daemon.reset();
+
+		spdlog::warn("Lost connection to the device, reconnecting...");
+
+		while (!daemon.has_value()) {
+			wait(delay);
+
+			if (Runner::stopping())
+				return 0;
+
+			try {
+				daemon.emplace(path, capture, capture_limit, dry_run, socket);
+				delay = RECONNECT_DELAY_MIN;
+			} catch (const std::exception &e) {
+				spdlog::warn("Failed to reconnect: {}", e.what());
+				delay = std::min(delay * 2, RECONNECT_DELAY_MAX);
+			}
+		}
+	}
+
+	return 0;
+}
+
 int run(const int argc, const char **argv)
 {
 	CLI::App app {"Daemon to translate touchscreen inputs to Linux input events."};
@@ -27,20 +249,63 @@ int run(const int argc, const char **argv)
 	app.add_option("DEVICE", path)
 		->description("The hidraw device node of the touchscreen.")
 		->type_name("FILE")
-		->required();
+		->check(CLI::ExistingFile);
 
-	CLI11_PARSE(app, argc, argv);
+	std::filesystem::path replay_path {};
+	app.add_option("--replay", replay_path)
+		->description("Replay data that was recorded by iptsd-dump, instead of a device.")
+		->type_name("FILE")
+		->check(CLI::ExistingFile);
 
-	// Create a daemon application that reads from a device.
-	core::linux::DeviceRunner<Daemon> daemon {path};
+	std::filesystem::path capture {};
+	app.add_option("--capture", capture)
+		->description("Record the data from the device to a file, for use with --replay.")
+		->type_name("FILE");
 
-	const auto _sigterm = core::linux::signal<SIGTERM>([&](int) { daemon.stop(); });
-	const auto _sigint = core::linux::signal<SIGINT>([&](int) { daemon.stop(); });
+	usize capture_limit = 0;
+	app.add_option("--capture-limit", capture_limit)
+		->description("Start a new capture file after this many MiB. The last 5 are kept.")
+		->type_name("SIZE");
+
+	bool dry_run = false;
+	app.add_flag("--dry-run", dry_run)
+		->description("Print the input events instead of creating input devices.");
+
+	std::string socket {};
+	app.add_option("--socket", socket)
+		->description("Send the input events to a unix socket instead of creating devices.")
+		->type_name("PATH");
+
+	bool probe = false;
+	app.add_flag("--probe", probe)
+		->description("Print the HID descriptor and metadata of the device, then exit.");
+
+	bool verbose = false;
+	app.add_flag("-v,--verbose", verbose)->description("Log every report that is received.");
 
-	if (!daemon.run())
+	CLI11_PARSE(app, argc, argv);
+
+	if (verbose)
+		spdlog::set_level(spdlog::level::debug);
+
+	if (path.empty() == replay_path.empty()) {
+		spdlog::error("Either a device or a file to replay is required!");
 		return EXIT_FAILURE;
+	}
 
-	return 0;
+	if (probe) {
+		if (path.empty()) {
+			spdlog::error("Probing requires a device!");
+			return EXIT_FAILURE;
+		}
+
+		return run_probe(path);
+	}
+
+	if (!replay_path.empty())
+		return run_replay(replay_path, dry_run, socket);
+
+	return run_device(path, capture, capture_limit * 1024 * 1024, dry_run, socket);
 }
 
 } // namespace
@@ -48,7 +313,13 @@ int run(const int argc, const char **argv)
 
 int main(const int argc, const char **argv)
 {
+#ifdef __ANDROID__
+	// Route the log output to logcat, which records the time and level on its own.
+	spdlog::set_default_logger(spdlog::android_logger_mt("iptsd", "iptsd"));
+	spdlog::set_pattern("%v");
+#else
 	spdlog::set_pattern("[%X.%e] [%^%l%$] %v");
+#endif
 
 	try {
 		return iptsd::apps::daemon::run(argc, argv);
@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_SCROLL_HPP
+#define IPTSD_APPS_DAEMON_SCROLL_HPP
+
+#include "emitter.hpp"
+
+#include <common/casts.hpp>
+#include <common/types.hpp>
+#include <contacts/contact.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+
+#include <linux/input-event-codes.h>
+
+#include <cmath>
+#include <memory>
+#include <optional>
+#include <utility>
+#include <vector>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * A relative pointer device that turns a drag with two fingers into scroll wheel events.
+ * This allows scrolling in applications that don't handle multitouch input.
+ */
+class ScrollDevice {
+private:
+	// Where the input events are sent to.
+	std::shared_ptr<Emitter> m_emitter;
+
+	// The daemon configuration.
+	core::Config m_config;
+
+	// The center between both fingers in the last frame, in centimeters.
+	std::optional<Vector2<f64>> m_last = std::nullopt;
+
+	// The movement that was not emitted yet, because it is smaller than one wheel step.
+	Vector2<f64> m_remainder = Vector2<f64>::Zero();
+
+public:
+	ScrollDevice(const core::Config &config,
+	             const core::DeviceInfo &info,
+	             std::shared_ptr<Emitter> emitter)
+		: m_emitter {std::move(emitter)},
+		  m_config {config}
+	{
+		u16 vendor = info.vendor;
+		u16 product = info.product;
+
+		// Configured IDs override the ones that were inherited from the IPTS device.
+		if (config.uinput_vendor != 0)
+			vendor = config.uinput_vendor;
+
+		if (config.uinput_product != 0)
+			product = config.uinput_product;
+
+		m_emitter->set_name(config.uinput_scroll_name);
+		m_emitter->set_vendor(vendor);
+		m_emitter->set_product(product);
+		m_emitter->set_version(config.uinput_version);
+
+		m_emitter->set_evbit(EV_KEY);
+		m_emitter->set_evbit(EV_REL);
+
+		// Without a button and pointer axes, the device is not recognized as a mouse.
+		m_emitter->set_keybit(BTN_LEFT);
+		m_emitter->set_relbit(REL_X);
+		m_emitter->set_relbit(REL_Y);
+		m_emitter->set_relbit(REL_WHEEL);
+		m_emitter->set_relbit(REL_HWHEEL);
+
+		m_emitter->create();
+	}
+
+	/*!
+	 * Emits scroll events if exactly two fingers are on the screen.
+	 *
+	 * @param[in] contacts All currently active contacts.
+	 * @return Whether the contacts are used for scrolling.
+	 */
+	bool update(const std::vector<contacts::Contact<f64>> &contacts)
+	{
+		std::optional<Vector2<f64>> center = this->center(contacts);
+
+		if (!center.has_value()) {
+			m_last.reset();
+			m_remainder = Vector2<f64>::Zero();
+
+			return false;
+		}
+
+		if (m_last.has_value())
+			m_remainder += center.value() - m_last.value();
+
+		m_last = center;
+
+		const f64 distance = m_config.touch_scroll_distance;
+
+		const i32 x = casts::to<i32>(std::trunc(m_remainder.x() / distance));
+		const i32 y = casts::to<i32>(std::trunc(m_remainder.y() / distance));
+
+		if (x == 0 && y == 0)
+			return true;
+
+		m_remainder.x() -= x * distance;
+		m_remainder.y() -= y * distance;
+
+		// Dragging down or right scrolls up or left, like on a touchscreen.
+		if (y != 0)
+			m_emitter->emit(EV_REL, REL_WHEEL, y);
+
+		if (x != 0)
+			m_emitter->emit(EV_REL, REL_HWHEEL, -x);
+
+		this->sync();
+		return true;
+	}
+
+	/*!
+	 * Applies a reloaded configuration to the device.
+	 *
+	 * @param[in] config The new daemon configuration.
+	 */
+	void reload(const core::Config &config)
+	{
+		m_config = config;
+	}
+
+private:
+	/*!
+	 * Calculates the center between the two fingers of a scroll gesture.
+	 *
+	 * @param[in] contacts All currently active contacts.
+	 * @return The center in centimeters, or nothing if there are not exactly two fingers.
+	 */
+	[[nodiscard]] std::optional<Vector2<f64>>
+	center(const std::vector<contacts::Contact<f64>> &contacts) const
+	{
+		std::vector<Vector2<f64>> fingers {};
+
+		for (const contacts::Contact<f64> &contact : contacts) {
+			if (!contact.valid.value_or(true))
+				continue;
+
+			fingers.push_back(contact.mean);
+		}
+
+		if (fingers.size() != 2)
+			return std::nullopt;
+
+		const Vector2<f64> center = (fingers[0] + fingers[1]) / 2;
+
+		const f64 x = center.x() * m_config.output_width();
+		const f64 y = center.y() * m_config.output_height();
+
+		return Vector2<f64> {x, y};
+	}
+
+	/*!
+	 * Commits the emitted events to the linux kernel.
+	 */
+	void sync() const
+	{
+		m_emitter->emit(EV_SYN, SYN_REPORT, 0);
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_SCROLL_HPP
@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_SINGLETOUCH_HPP
+#define IPTSD_APPS_DAEMON_SINGLETOUCH_HPP
+
+#include "emitter.hpp"
+
+#include <common/casts.hpp>
+#include <common/types.hpp>
+#include <contacts/contact.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+
+#include <linux/input-event-codes.h>
+
+#include <algorithm>
+#include <cmath>
+#include <memory>
+#include <optional>
+#include <utility>
+#include <vector>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * A fallback for input stacks that don't handle the linux multitouch protocol well.
+ * Only the primary contact is emitted, using the linux singletouch protocol.
+ */
+class SingleTouchDevice {
+private:
+	constexpr static usize MAX_X = 9600;
+	constexpr static usize MAX_Y = 7200;
+
+private:
+	// Where the input events are sent to.
+	std::shared_ptr<Emitter> m_emitter;
+
+	// The daemon configuration.
+	core::Config m_config;
+
+	// The maximum coordinates, after the axes were rotated like the screen.
+	i32 m_max_x = MAX_X;
+	i32 m_max_y = MAX_Y;
+
+	// The index of the contact that is currently emitted.
+	std::optional<usize> m_index = std::nullopt;
+
+public:
+	SingleTouchDevice(const core::Config &config,
+	                  const core::DeviceInfo &info,
+	                  std::shared_ptr<Emitter> emitter)
+		: m_emitter {std::move(emitter)},
+		  m_config {config}
+	{
+		u16 vendor = info.vendor;
+		u16 product = info.product;
+
+		// Configured IDs override the ones that were inherited from the IPTS device.
+		if (config.uinput_vendor != 0)
+			vendor = config.uinput_vendor;
+
+		if (config.uinput_product != 0)
+			product = config.uinput_product;
+
+		m_emitter->set_name(config.uinput_singletouch_name);
+		m_emitter->set_vendor(vendor);
+		m_emitter->set_product(product);
+		m_emitter->set_version(config.uinput_version);
+
+		m_emitter->set_evbit(EV_ABS);
+		m_emitter->set_evbit(EV_KEY);
+
+		m_emitter->set_propbit(INPUT_PROP_DIRECT);
+		m_emitter->set_keybit(BTN_TOUCH);
+
+		if (config.swaps_axes())
+			std::swap(m_max_x, m_max_y);
+
+		// Map the coordinates onto the pixels of the screen, if its resolution is known.
+		if (config.screen_width > 0 && config.screen_height > 0) {
+			m_max_x = casts::to<i32>(config.screen_width - 1);
+			m_max_y = casts::to<i32>(config.screen_height - 1);
+		}
+
+		const f64 width = config.output_width();
+		const f64 height = config.output_height();
+
+		// Resolution for X / Y is expected to be units/mm.
+		const i32 res_x = casts::to<i32>(std::round(m_max_x / (width * 10)));
+		const i32 res_y = casts::to<i32>(std::round(m_max_y / (height * 10)));
+
+		m_emitter->set_absinfo(ABS_X, 0, m_max_x, res_x);
+		m_emitter->set_absinfo(ABS_Y, 0, m_max_y, res_y);
+
+		m_emitter->create();
+	}
+
+	/*!
+	 * Emits the primary contact of the current frame.
+	 *
+	 * @param[in] contacts All currently active contacts.
+	 */
+	void update(const std::vector<contacts::Contact<f64>> &contacts)
+	{
+		const std::optional<contacts::Contact<f64>> contact = this->select(contacts);
+
+		if (!contact.has_value()) {
+			this->lift();
+			return;
+		}
+
+		m_index = contact->index;
+
+		// Ignore unstable changes
+		if (!contact->stable.value_or(true))
+			return;
+
+		Vector2<f64> mean = contact->mean;
+
+		mean.x() = std::clamp(mean.x(), 0.0, 1.0);
+		mean.y() = std::clamp(mean.y(), 0.0, 1.0);
+
+		const i32 x = casts::to<i32>(std::round(mean.x() * m_max_x));
+		const i32 y = casts::to<i32>(std::round(mean.y() * m_max_y));
+
+		m_emitter->emit(EV_KEY, BTN_TOUCH, 1);
+		m_emitter->emit(EV_ABS, ABS_X, x);
+		m_emitter->emit(EV_ABS, ABS_Y, y);
+
+		this->sync();
+	}
+
+	/*!
+	 * Applies a reloaded configuration to the device.
+	 *
+	 * The axes of the uinput device stay the same until it is recreated.
+	 *
+	 * @param[in] config The new daemon configuration.
+	 */
+	void reload(const core::Config &config)
+	{
+		m_config = config;
+	}
+
+	/*!
+	 * Lifts the primary contact, if there is one.
+	 */
+	void lift()
+	{
+		if (!m_index.has_value())
+			return;
+
+		m_index.reset();
+
+		m_emitter->emit(EV_KEY, BTN_TOUCH, 0);
+		this->sync();
+	}
+
+private:
+	/*!
+	 * Selects the contact that is emitted as the primary contact.
+	 *
+	 * The current primary contact is kept for as long as it is active. Otherwise,
+	 * the first valid contact of the frame is selected.
+	 *
+	 * @param[in] contacts All currently active contacts.
+	 * @return The primary contact, or nothing if there are no valid contacts.
+	 */
+	[[nodiscard]] std::optional<contacts::Contact<f64>>
+	select(const std::vector<contacts::Contact<f64>> &contacts) const
+	{
+		const auto is_valid = [](const contacts::Contact<f64> &contact) {
+			return contact.index.has_value() && contact.valid.value_or(true);
+		};
+
+		if (this->is_blocked(contacts))
+			return std::nullopt;
+
+		if (m_index.has_value()) {
+			for (const contacts::Contact<f64> &contact : contacts) {
+				if (contact.index == m_index && is_valid(contact))
+					return contact;
+			}
+		}
+
+		for (const contacts::Contact<f64> &contact : contacts) {
+			if (is_valid(contact))
+				return contact;
+		}
+
+		return std::nullopt;
+	}
+
+	/*!
+	 * Checks if the touchscreen should be disabled because of a palm on the screen.
+	 *
+	 * @param[in] contacts All currently active contacts.
+	 * @return true if all contacts should be lifted.
+	 */
+	[[nodiscard]] bool is_blocked(const std::vector<contacts::Contact<f64>> &contacts) const
+	{
+		if (!m_config.touch_disable_on_palm)
+			return false;
+
+		return std::any_of(contacts.cbegin(), contacts.cend(), [&](const auto &c) {
+			return !c.valid.value_or(true);
+		});
+	}
+
+	/*!
+	 * Commits the emitted events to the linux kernel.
+	 */
+	void sync() const
+	{
+		m_emitter->emit(EV_SYN, SYN_REPORT, 0);
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_SINGLETOUCH_HPP
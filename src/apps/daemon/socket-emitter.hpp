@@ -0,0 +1,306 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_SOCKET_EMITTER_HPP
+#define IPTSD_APPS_DAEMON_SOCKET_EMITTER_HPP
+
+#include "emitter.hpp"
+
+#include <common/casts.hpp>
+#include <common/chrono.hpp>
+#include <common/types.hpp>
+#include <core/linux/syscalls.hpp>
+
+#include <fmt/format.h>
+#include <gsl/gsl>
+#include <spdlog/spdlog.h>
+
+#include <linux/input-event-codes.h>
+
+#include <exception>
+#include <poll.h>
+#include <string>
+#include <sys/socket.h>
+#include <utility>
+
+namespace syscalls = iptsd::core::linux::syscalls;
+
+namespace iptsd::apps::daemon {
+
+/*
+ * Sends the generated input events to a unix socket, instead of passing them to the kernel.
+ *
+ * This allows a service on the other end to inject the events (e.g. through Android's
+ * InputManager), on systems where uinput is not available. Every device opens its own
+ * connection, and describes itself before sending any events. See the README for the format.
+ */
+class SocketEmitter : public Emitter {
+private:
+	/*
+	 * How long to wait for the other end to accept the rest of a frame that was only sent
+	 * partially, before the connection is given up.
+	 */
+	constexpr static int PARTIAL_TIMEOUT = 10;
+
+	/*
+	 * How often to try connecting again after the connection was lost.
+	 */
+	constexpr static chrono::seconds RECONNECT_INTERVAL = 1s;
+
+private:
+	std::string m_name;
+	u16 m_vendor = 0;
+	u16 m_product = 0;
+	u16 m_version = 0;
+
+	// The path of the socket, for connecting again.
+	std::string m_path;
+
+	// The file descriptor of the connected socket, or -1 if the connection was lost.
+	mutable int m_fd;
+
+	// The lines that describe the device, which are sent again after reconnecting.
+	mutable std::string m_description {};
+
+	// When the connection was lost.
+	mutable chrono::steady_clock::time_point m_lost {};
+
+	// The events that were emitted since the last SYN_REPORT.
+	std::string m_events {};
+
+public:
+	SocketEmitter(std::string path)
+		: m_path {std::move(path)},
+		  m_fd {SocketEmitter::connect(m_path)} {};
+
+	~SocketEmitter() override
+	{
+		this->disconnect();
+	}
+
+	void set_name(std::string name) override
+	{
+		m_name = std::move(name);
+	}
+
+	void set_vendor(const u16 vendor) override
+	{
+		m_vendor = vendor;
+	}
+
+	void set_product(const u16 product) override
+	{
+		m_product = product;
+	}
+
+	void set_version(const u16 version) override
+	{
+		m_version = version;
+	}
+
+	void set_evbit(const i32 ev) const override
+	{
+		m_description += fmt::format("evbit {}\n", ev);
+	}
+
+	void set_propbit(const i32 prop) const override
+	{
+		m_description += fmt::format("propbit {}\n", prop);
+	}
+
+	void set_keybit(const i32 key) const override
+	{
+		m_description += fmt::format("keybit {}\n", key);
+	}
+
+	void set_relbit(const i32 rel) const override
+	{
+		m_description += fmt::format("relbit {}\n", rel);
+	}
+
+	void set_mscbit(const i32 msc) const override
+	{
+		m_description += fmt::format("mscbit {}\n", msc);
+	}
+
+	void set_absinfo(const u16 code, const i32 min, const i32 max, const i32 res) const override
+	{
+		m_description += fmt::format("absinfo {} {} {} {}\n", code, min, max, res);
+	}
+
+	/*!
+	 * Finalizes the device creation.
+	 *
+	 * The name and the IDs are sent last, so that they can be set in any order.
+	 */
+	void create() const override
+	{
+		m_description += fmt::format("id {} {} {}\n", m_vendor, m_product, m_version);
+		m_description += fmt::format("create {}\n", m_name);
+
+		this->describe();
+	}
+
+	/*!
+	 * Emits an event.
+	 *
+	 * Events are collected until a SYN_REPORT is emitted, and then sent all at once,
+	 * so that the other end never sees an incomplete frame. Sending never blocks: if the
+	 * other end doesn't keep up, the frame is dropped. If the connection is lost, the frames
+	 * are dropped until connecting again succeeds.
+	 *
+	 * @param[in] type The event type.
+	 * @param[in] key The key of the button or axis.
+	 * @param[in] value The value of the button or axis.
+	 */
+	void emit(const u16 type, const u16 key, const i32 value) override
+	{
+		m_events += fmt::format("event {} {} {}\n", type, key, value);
+
+		if (type != EV_SYN || key != SYN_REPORT)
+			return;
+
+		if (m_fd == -1)
+			this->reconnect();
+
+		if (m_fd != -1 && !this->send(m_events))
+			spdlog::debug("Dropping frame, the socket is not keeping up");
+
+		m_events.clear();
+	}
+
+private:
+	/*!
+	 * Opens a connection to the socket.
+	 *
+	 * @param[in] path The path of the socket.
+	 * @return The file descriptor of the connected socket.
+	 */
+	[[nodiscard]] static int connect(const std::string &path)
+	{
+		const int fd = syscalls::socket(AF_UNIX, SOCK_STREAM);
+
+		try {
+			syscalls::connect(fd, path);
+		} catch (const std::exception & /* unused */) {
+			syscalls::close(fd);
+			throw;
+		}
+
+		return fd;
+	}
+
+	/*!
+	 * Tries to connect again after the connection was lost, and describes the device again.
+	 */
+	void reconnect()
+	{
+		if (chrono::steady_clock::now() - m_lost < RECONNECT_INTERVAL)
+			return;
+
+		try {
+			m_fd = SocketEmitter::connect(m_path);
+		} catch (const std::exception & /* unused */) {
+			m_lost = chrono::steady_clock::now();
+			return;
+		}
+
+		spdlog::info("Connected to {} again", m_path);
+		this->describe();
+	}
+
+	/*!
+	 * Sends the description of the device.
+	 *
+	 * The other end can't handle any events without it, so the connection is closed if the
+	 * description can't be sent.
+	 */
+	void describe() const
+	{
+		if (m_fd != -1 && !this->send(m_description))
+			this->disconnect();
+	}
+
+	/*!
+	 * Sends a chunk of text to the socket, without blocking.
+	 *
+	 * If the other end doesn't accept any data, nothing is sent. If the connection is broken,
+	 * it is closed.
+	 *
+	 * @param[in] text The lines to send.
+	 * @return Whether the text was sent.
+	 */
+	bool send(const std::string &text) const
+	{
+		try {
+			return this->send_all(text);
+		} catch (const std::exception &e) {
+			spdlog::warn("Lost the connection to {}: {}", m_path, e.what());
+			this->disconnect();
+		}
+
+		return false;
+	}
+
+	/*!
+	 * Sends a chunk of text to the socket, even if that takes more than one call.
+	 *
+	 * Once a part of the text was sent, the rest has to follow, otherwise the other end would
+	 * see a broken line. If the other end doesn't accept the rest in time, the connection is
+	 * closed.
+	 *
+	 * @param[in] text The lines to send.
+	 * @return Whether the text was sent.
+	 */
+	bool send_all(const std::string &text) const
+	{
+		usize sent = 0;
+
+		while (sent < text.size()) {
+			const gsl::span<const char> rest {text.data() + sent, text.size() - sent};
+			const isize ret = syscalls::send(m_fd, rest, MSG_DONTWAIT);
+
+			if (ret > 0) {
+				sent += casts::to_unsigned(ret);
+				continue;
+			}
+
+			// The whole text can be dropped without breaking the stream.
+			if (sent == 0)
+				return false;
+
+			struct pollfd fd {};
+			fd.fd = m_fd;
+			fd.events = POLLOUT;
+
+			if (syscalls::poll(fd, PARTIAL_TIMEOUT) == 0) {
+				spdlog::warn("{} stopped reading, disconnecting", m_path);
+				this->disconnect();
+				return false;
+			}
+		}
+
+		return true;
+	}
+
+	/*!
+	 * Closes the connection, if it is open.
+	 */
+	void disconnect() const
+	{
+		if (m_fd == -1)
+			return;
+
+		try {
+			syscalls::close(m_fd);
+		} catch (const std::exception & /* unused */) {
+			// ignored
+		}
+
+		m_fd = -1;
+		m_lost = chrono::steady_clock::now();
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_SOCKET_EMITTER_HPP
@@ -3,21 +3,28 @@
 #ifndef IPTSD_APPS_DAEMON_STYLUS_HPP
 #define IPTSD_APPS_DAEMON_STYLUS_HPP
 
-#include "uinput-device.hpp"
+#include "emitter.hpp"
 
 #include <common/casts.hpp>
+#include <common/chrono.hpp>
 #include <common/types.hpp>
 #include <core/generic/config.hpp>
 #include <core/generic/device.hpp>
 #include <ipts/data.hpp>
 
 #include <gsl/gsl>
+#include <spdlog/spdlog.h>
 
 #include <linux/input-event-codes.h>
+#include <linux/input.h>
 
+#include <algorithm>
 #include <climits>
 #include <cmath>
+#include <map>
 #include <memory>
+#include <optional>
+#include <utility>
 
 namespace iptsd::apps::daemon {
 
@@ -25,10 +32,70 @@ class StylusDevice {
 private:
 	constexpr static usize MAX_X = 9600;
 	constexpr static usize MAX_Y = 7200;
-	constexpr static usize MAX_P = 4096;
+
+	/*
+	 * The protocol doesn't report how far the stylus is away from the display.
+	 * Until that can be derived, only hovering and touching are distinguished.
+	 */
+	constexpr static usize MAX_D = 1;
+
+	/*
+	 * How far the smoothed tilt may lag behind the measured one, in radians.
+	 * This keeps smoothing from swallowing fast rotations of the stylus.
+	 */
+	constexpr static f64 MAX_TILT_LAG = 5 * M_PI / 180;
+
+	/*
+	 * How far the stylus has to move past the middle between two grid lines before it snaps
+	 * to the next one, relative to the grid spacing. This makes the grid lines slightly sticky,
+	 * so the position doesn't jump back and forth while the stylus is close to the middle.
+	 */
+	constexpr static f64 SNAP_HYSTERESIS = 0.2;
 
 private:
-	std::shared_ptr<UinputDevice> m_uinput = std::make_shared<UinputDevice>();
+	struct State {
+		// The last event that was processed for this stylus.
+		ipts::StylusData last {};
+
+		// The timestamp of this stylus, extended beyond 16 bits.
+		u32 timestamp = 0;
+
+		// The last measured position and velocity, for predicting the next position.
+		Vector2<f64> position = Vector2<f64>::Zero();
+		Vector2<f64> velocity = Vector2<f64>::Zero();
+
+		// The extended timestamp of the report that the last position was measured in.
+		u32 measured = 0;
+
+		// How many reports were discarded since the stylus entered proximity.
+		usize discarded = 0;
+
+		// The smoothed position of the stylus. Reset when the stylus leaves proximity.
+		std::optional<Vector2<f64>> smoothed = std::nullopt;
+
+		// The smoothed tilt on the X and Y axis, in radians. Reset when the stylus leaves
+		// proximity or switches tools.
+		std::optional<Vector2<f64>> tilt = std::nullopt;
+
+		// Whether the stylus is used as an eraser, and for how many reports in a row it
+		// reported the other tool. Reset when the stylus leaves proximity.
+		bool rubber = false;
+		usize rubber_reports = 0;
+
+		// Whether the tool was taken from a report since the stylus entered proximity.
+		bool rubber_known = false;
+	};
+
+private:
+	// Where the input events are sent to.
+	std::shared_ptr<Emitter> m_emitter;
+
+	// The daemon configuration.
+	core::Config m_config;
+
+	// The maximum coordinates, after the axes were rotated like the screen.
+	i32 m_max_x = MAX_X;
+	i32 m_max_y = MAX_Y;
 
 	// Whether the device is enabled.
 	bool m_enabled = true;
@@ -36,42 +103,161 @@ private:
 	// Whether the stylus is currently in proximity and sending data.
 	bool m_active = false;
 
-	// The last stylus event that was processed.
-	ipts::StylusData m_last;
+	// Whether the stylus was reported as touching the display.
+	bool m_contact = false;
+
+	// Whether the stylus was in proximity in the last report that was not discarded.
+	bool m_proximity = false;
+
+	// The serial number of the stylus that was processed last.
+	std::optional<u32> m_serial = std::nullopt;
+
+	// The state of every known stylus, keyed by serial number.
+	// Reports without a serial number (e.g. from DFT styli) share the slot of serial 0.
+	std::map<u32, State> m_styli {};
+
+	// When the last report was received from the stylus.
+	chrono::steady_clock::time_point m_last_report {};
+
+	// The smoothed position of the active stylus. Reset when it leaves proximity.
+	std::optional<Vector2<f64>> m_position = std::nullopt;
+
+	// Whether the barrel button was pressed in the last report.
+	bool m_button = false;
+
+	// When the barrel button was pressed, if it is unknown yet whether it was a double press
+	// or whether it is held down.
+	std::optional<chrono::steady_clock::time_point> m_button_pending = std::nullopt;
+
+	// The key that is currently held down for the barrel button, if any.
+	std::optional<u16> m_button_key = std::nullopt;
+
+	// Whether snapping to the grid was switched on with the barrel button.
+	bool m_snap = false;
+
+	// The grid lines the stylus is snapped to, in centimeters. Reset when it leaves proximity.
+	std::optional<Vector2<f64>> m_snapped = std::nullopt;
+
+	// When the device was created. MSC_TIMESTAMP counts from here, unless it uses the stylus.
+	chrono::steady_clock::time_point m_created = chrono::steady_clock::now();
+
+	// The last report that was emitted, and when it was emitted.
+	std::optional<ipts::StylusData> m_emitted = std::nullopt;
+	chrono::steady_clock::time_point m_emitted_time {};
+
+	// The latest report that was held back to limit the rate of emitted events.
+	std::optional<ipts::StylusData> m_pending = std::nullopt;
 
 public:
-	StylusDevice(const core::Config &config, const core::DeviceInfo &info)
+	StylusDevice(const core::Config &config,
+	             const core::DeviceInfo &info,
+	             std::shared_ptr<Emitter> emitter)
+		: m_emitter {std::move(emitter)},
+		  m_config {config}
 	{
-		m_uinput->set_name("IPTS Stylus");
-		m_uinput->set_vendor(info.vendor);
-		m_uinput->set_product(info.product);
+		u16 vendor = info.vendor;
+		u16 product = info.product;
+
+		// Configured IDs override the ones that were inherited from the IPTS device.
+		if (config.uinput_vendor != 0)
+			vendor = config.uinput_vendor;
+
+		if (config.uinput_product != 0)
+			product = config.uinput_product;
+
+		m_emitter->set_name(config.uinput_stylus_name);
+		m_emitter->set_vendor(vendor);
+		m_emitter->set_product(product);
+		m_emitter->set_version(config.uinput_version);
+
+		m_emitter->set_evbit(EV_KEY);
+		m_emitter->set_evbit(EV_ABS);
 
-		m_uinput->set_evbit(EV_KEY);
-		m_uinput->set_evbit(EV_ABS);
+		if (config.stylus_emit_serial || config.stylus_emit_timestamp)
+			m_emitter->set_evbit(EV_MSC);
 
-		m_uinput->set_propbit(INPUT_PROP_DIRECT);
-		m_uinput->set_propbit(INPUT_PROP_POINTER);
+		if (config.stylus_emit_serial)
+			m_emitter->set_mscbit(MSC_SERIAL);
 
-		m_uinput->set_keybit(BTN_TOUCH);
-		m_uinput->set_keybit(BTN_STYLUS);
-		m_uinput->set_keybit(BTN_TOOL_PEN);
-		m_uinput->set_keybit(BTN_TOOL_RUBBER);
+		if (config.stylus_emit_timestamp)
+			m_emitter->set_mscbit(MSC_TIMESTAMP);
+
+		m_emitter->set_propbit(INPUT_PROP_DIRECT);
+		m_emitter->set_propbit(INPUT_PROP_POINTER);
+
+		m_emitter->set_keybit(BTN_TOUCH);
+		m_emitter->set_keybit(config.stylus_key(core::StylusBit::Button));
+
+		if (config.stylus_key(core::StylusBit::Rubber) != 0)
+			m_emitter->set_keybit(config.stylus_key(core::StylusBit::Rubber));
+
+		if (config.stylus_button_double_key != 0)
+			m_emitter->set_keybit(config.stylus_button_double_key);
+
+		if (config.stylus_button_hold_key != 0)
+			m_emitter->set_keybit(config.stylus_button_hold_key);
+
+		if (config.stylus_wake_key != 0)
+			m_emitter->set_keybit(config.stylus_wake_key);
+
+		m_emitter->set_keybit(BTN_TOOL_PEN);
+
+		if (config.reports_rubber_tool())
+			m_emitter->set_keybit(BTN_TOOL_RUBBER);
+
+		if (config.swaps_axes())
+			std::swap(m_max_x, m_max_y);
+
+		// Map the coordinates onto the pixels of the screen, if its resolution is known.
+		if (config.screen_width > 0 && config.screen_height > 0) {
+			m_max_x = casts::to<i32>(config.screen_width - 1);
+			m_max_y = casts::to<i32>(config.screen_height - 1);
+		}
+
+		const f64 width = config.output_width();
+		const f64 height = config.output_height();
+
+		// The digitizer only covers the output region of the screen.
+		const f64 span_x = config.stylus_output_right - config.stylus_output_left;
+		const f64 span_y = config.stylus_output_bottom - config.stylus_output_top;
 
 		// Resolution for X / Y is expected to be units/mm.
-		const i32 res_x = casts::to<i32>(std::round(MAX_X / (config.width * 10)));
-		const i32 res_y = casts::to<i32>(std::round(MAX_Y / (config.height * 10)));
+		const i32 res_x = casts::to<i32>(std::round(m_max_x * span_x / (width * 10)));
+		const i32 res_y = casts::to<i32>(std::round(m_max_y * span_y / (height * 10)));
 
 		// Resolution for tilt is expected to be units/radian.
-		const i32 res_tilt = casts::to<i32>(std::round(18000.0 / M_PI));
+		const i32 max_tilt = config.stylus_tilt_max;
+		const i32 res_tilt = casts::to<i32>(std::round(max_tilt / M_PI_2));
+
+		m_emitter->set_absinfo(ABS_X, 0, m_max_x, res_x);
+		m_emitter->set_absinfo(ABS_Y, 0, m_max_y, res_y);
+
+		const i32 min_p = config.stylus_output_pressure_min;
+		const i32 max_p = config.stylus_output_pressure_max;
+
+		m_emitter->set_absinfo(ABS_PRESSURE, min_p, max_p, 0);
+		m_emitter->set_absinfo(ABS_DISTANCE, 0, MAX_D, 0);
+
+		if (!config.stylus_disable_tilt) {
+			m_emitter->set_absinfo(ABS_TILT_X, -max_tilt, max_tilt, res_tilt);
+			m_emitter->set_absinfo(ABS_TILT_Y, -max_tilt, max_tilt, res_tilt);
+		}
+
+		// The extended timestamp uses the full (positive) range of the axis.
+		const i32 max_timestamp = config.stylus_raw_timestamp ? USHRT_MAX : INT_MAX;
+		m_emitter->set_absinfo(ABS_MISC, 0, max_timestamp, 0);
 
-		m_uinput->set_absinfo(ABS_X, 0, MAX_X, res_x);
-		m_uinput->set_absinfo(ABS_Y, 0, MAX_Y, res_y);
-		m_uinput->set_absinfo(ABS_PRESSURE, 0, MAX_P, 0);
-		m_uinput->set_absinfo(ABS_TILT_X, -9000, 9000, res_tilt);
-		m_uinput->set_absinfo(ABS_TILT_Y, -9000, 9000, res_tilt);
-		m_uinput->set_absinfo(ABS_MISC, 0, USHRT_MAX, 0);
+		if (config.stylus_multitouch) {
+			m_emitter->set_absinfo(ABS_MT_SLOT, 0, 0, 0);
+			m_emitter->set_absinfo(ABS_MT_TRACKING_ID, 0, 0, 0);
+			m_emitter->set_absinfo(ABS_MT_TOOL_TYPE, 0, MT_TOOL_MAX, 0);
+			m_emitter->set_absinfo(ABS_MT_POSITION_X, 0, m_max_x, res_x);
+			m_emitter->set_absinfo(ABS_MT_POSITION_Y, 0, m_max_y, res_y);
+			m_emitter->set_absinfo(ABS_MT_PRESSURE, min_p, max_p, 0);
+			m_emitter->set_absinfo(ABS_MT_DISTANCE, 0, MAX_D, 0);
+		}
 
-		m_uinput->create();
+		m_emitter->create();
 	}
 
 	/*!
@@ -81,40 +267,231 @@ public:
 	 */
 	void update(const ipts::StylusData &data)
 	{
+		if (this->coalesce(data))
+			return;
+
+		m_pending.reset();
+		m_emitted = data;
+		m_emitted_time = chrono::steady_clock::now();
+
+		// A different stylus took over, release everything the previous one was holding.
+		if (m_serial.has_value() && m_serial != data.serial) {
+			this->lift();
+			this->sync();
+			this->leave_proximity();
+
+			m_position.reset();
+		}
+
+		m_serial = data.serial;
+		m_last_report = chrono::steady_clock::now();
+
+		if (m_styli.find(data.serial) == m_styli.end())
+			spdlog::info("Detected new stylus with serial {:08X}", data.serial);
+
+		State &state = m_styli[data.serial];
+		const ipts::StylusData &last = state.last;
+
+		// The difference between the timestamps stays correct when they wrap around.
+		state.timestamp += gsl::narrow_cast<u16>(data.timestamp - last.timestamp);
+
+		// The first reports after entering proximity often contain garbage, until the
+		// digitizer has locked onto the stylus.
+		if (!data.proximity) {
+			this->leave_proximity();
+		} else if (state.discarded < m_config.stylus_warmup_reports) {
+			state.discarded++;
+			state.last = data;
+
+			m_active = false;
+			return;
+		}
+
 		m_active = data.proximity;
 
+		// Tap the wake key once when the stylus approaches, not for every hovering report.
+		if (data.proximity && !m_proximity && m_config.stylus_wake_key != 0) {
+			m_emitter->emit(EV_KEY, m_config.stylus_wake_key, 1);
+			this->sync();
+			m_emitter->emit(EV_KEY, m_config.stylus_wake_key, 0);
+		}
+
+		m_proximity = data.proximity;
+
+		const bool was_known = state.rubber_known;
+		const bool was_rubber = state.rubber;
+		const bool is_rubber = this->debounce_rubber(state, data);
+
 		// Switching tools within one frame causes issues, lift the stylus for one frame.
-		if (m_last.rubber != data.rubber)
+		if (was_known && was_rubber != is_rubber && m_config.reports_rubber_tool()) {
 			m_active = false;
+			state.tilt.reset();
+		}
 
 		if (m_active) {
-			const Vector2<i32> tilt = calculate_tilt(data.altitude, data.azimuth);
+			// A new stroke doesn't have a velocity yet.
+			const bool entered = !state.smoothed.has_value();
+
+			const Vector2<f64> smoothed = this->smooth_position(state, data);
+			m_position = smoothed;
+
+			Vector2<f64> position = this->predict_position(state, smoothed, entered);
+			position = this->clamp_position(position);
+			position = this->snap_position(position);
+
+			// Ignore contacts with too little pressure, the stylus is just grazing.
+			// Once down, it only lifts when the pressure drops below the release value.
+			const f64 release = m_config.stylus_contact_release_pressure;
+			bool pressed = data.pressure > m_config.stylus_contact_min_pressure;
+
+			if (m_contact && release > 0)
+				pressed = data.pressure >= release;
+
+			const bool contact = data.contact && pressed;
+			m_contact = contact;
+
+			const Vector2<f64> output = this->map_output(position);
+
+			const i32 x = casts::to<i32>(std::round(output.x() * m_max_x));
+			const i32 y = casts::to<i32>(std::round(output.y() * m_max_y));
+			const f64 curved = contact ? this->apply_pressure_curve(data.pressure) : 0;
+			const i32 pressure = this->scale_pressure(curved);
+
+			// An eraser mapped to a key or a shortcut is reported as a regular pen.
+			const bool rubber = is_rubber && m_config.reports_rubber_tool();
+			const u16 rubber_key = m_config.stylus_key(core::StylusBit::Rubber);
+
+			m_emitter->emit(EV_KEY, BTN_TOUCH, contact ? 1 : 0);
+			m_emitter->emit(EV_KEY, BTN_TOOL_PEN, !rubber ? 1 : 0);
+			m_emitter->emit(EV_KEY, BTN_TOOL_RUBBER, rubber ? 1 : 0);
+			this->update_button(data.button);
+
+			if (rubber_key != 0)
+				m_emitter->emit(EV_KEY, rubber_key, is_rubber ? 1 : 0);
+
+			// The cursor can be kept in place until the stylus touches the screen.
+			if (contact || !m_config.stylus_disable_hover) {
+				m_emitter->emit(EV_ABS, ABS_X, x);
+				m_emitter->emit(EV_ABS, ABS_Y, y);
+			}
+
+			m_emitter->emit(EV_ABS, ABS_PRESSURE, pressure);
+			m_emitter->emit(EV_ABS, ABS_DISTANCE, contact ? 0 : MAX_D);
+			m_emitter->emit(EV_ABS, ABS_MISC, this->timestamp(data, state));
+
+			// The serial is passed on bit by bit, the kernel doesn't interpret it.
+			if (m_config.stylus_emit_serial) {
+				const auto serial = gsl::narrow_cast<i32>(data.serial);
+				m_emitter->emit(EV_MSC, MSC_SERIAL, serial);
+			}
+
+			if (m_config.stylus_emit_timestamp)
+				m_emitter->emit(EV_MSC, MSC_TIMESTAMP, this->msc_timestamp(state));
+
+			// Styli that don't report their orientation keep the tilt axes untouched.
+			if (data.has_tilt && !m_config.stylus_disable_tilt) {
+				const f64 altitude = this->clamp_altitude(data.altitude);
+				const f64 azimuth = data.azimuth;
+
+				Vector2<f64> tilt = StylusDevice::calculate_tilt(altitude, azimuth);
+				tilt = this->smooth_tilt(state, tilt);
+
+				const Vector2<i32> scaled = this->scale_tilt(tilt);
 
-			const i32 x = casts::to<i32>(std::round(data.x * MAX_X));
-			const i32 y = casts::to<i32>(std::round(data.y * MAX_Y));
-			const i32 pressure = casts::to<i32>(std::round(data.pressure * MAX_P));
+				m_emitter->emit(EV_ABS, ABS_TILT_X, scaled.x());
+				m_emitter->emit(EV_ABS, ABS_TILT_Y, scaled.y());
+			}
 
-			m_uinput->emit(EV_KEY, BTN_TOUCH, data.contact ? 1 : 0);
-			m_uinput->emit(EV_KEY, BTN_TOOL_PEN, !data.rubber ? 1 : 0);
-			m_uinput->emit(EV_KEY, BTN_TOOL_RUBBER, data.rubber ? 1 : 0);
-			m_uinput->emit(EV_KEY, BTN_STYLUS, data.button ? 1 : 0);
+			if (m_config.stylus_multitouch) {
+				m_emitter->emit(EV_ABS, ABS_MT_SLOT, 0);
+				m_emitter->emit(EV_ABS, ABS_MT_TRACKING_ID, 0);
+				m_emitter->emit(EV_ABS, ABS_MT_TOOL_TYPE, MT_TOOL_PEN);
 
-			m_uinput->emit(EV_ABS, ABS_X, x);
-			m_uinput->emit(EV_ABS, ABS_Y, y);
-			m_uinput->emit(EV_ABS, ABS_PRESSURE, pressure);
-			m_uinput->emit(EV_ABS, ABS_MISC, data.timestamp);
+				if (contact || !m_config.stylus_disable_hover) {
+					m_emitter->emit(EV_ABS, ABS_MT_POSITION_X, x);
+					m_emitter->emit(EV_ABS, ABS_MT_POSITION_Y, y);
+				}
 
-			m_uinput->emit(EV_ABS, ABS_TILT_X, tilt.x());
-			m_uinput->emit(EV_ABS, ABS_TILT_Y, tilt.y());
+				m_emitter->emit(EV_ABS, ABS_MT_PRESSURE, pressure);
+				m_emitter->emit(EV_ABS, ABS_MT_DISTANCE, contact ? 0 : MAX_D);
+			}
 		} else {
 			this->lift();
+
+			state.smoothed.reset();
+			m_position.reset();
 		}
 
-		m_last = data;
+		state.last = data;
 
 		this->sync();
 	}
 
+	/*!
+	 * Lifts the stylus if the device didn't send any reports for a while.
+	 */
+	void check_timeout()
+	{
+		if (m_config.stylus_timeout == 0 || !m_active)
+			return;
+
+		const auto timeout = casts::to<chrono::milliseconds::rep>(m_config.stylus_timeout);
+
+		if (chrono::steady_clock::now() - m_last_report < chrono::milliseconds {timeout})
+			return;
+
+		m_active = false;
+		m_proximity = false;
+		m_position.reset();
+
+		this->lift();
+		this->leave_proximity();
+		m_emitter->emit(EV_ABS, ABS_PRESSURE, this->scale_pressure(0));
+		this->sync();
+	}
+
+	/*!
+	 * Emits a pending press of the barrel button once its window or hold time is over.
+	 */
+	void check_button()
+	{
+		if (this->expire_button())
+			this->sync();
+	}
+
+	/*!
+	 * Emits the report that was held back by the rate limit, once it may be emitted.
+	 */
+	void check_pending()
+	{
+		if (!m_pending.has_value())
+			return;
+
+		// The limit might have been turned off by reloading the config.
+		if (m_config.stylus_max_rate != 0) {
+			const f64 rate = casts::to<f64>(m_config.stylus_max_rate);
+			const seconds<f64> interval {1.0 / rate};
+
+			if (chrono::steady_clock::now() - m_emitted_time < interval)
+				return;
+		}
+
+		const ipts::StylusData data = m_pending.value();
+		this->update(data);
+	}
+
+	/*!
+	 * Applies a reloaded configuration to the device.
+	 *
+	 * The axes of the uinput device stay the same until it is recreated.
+	 *
+	 * @param[in] config The new daemon configuration.
+	 */
+	void reload(const core::Config &config)
+	{
+		m_config = config;
+	}
+
 	/*!
 	 * Disables and lifts the stylus.
 	 */
@@ -122,9 +499,13 @@ public:
 	{
 		m_enabled = false;
 		m_active = false;
+		m_proximity = false;
+		m_position.reset();
+		m_pending.reset();
 
 		// Lift all currently active contacts.
 		this->lift();
+		this->leave_proximity();
 		this->sync();
 	}
 
@@ -156,19 +537,42 @@ public:
 		return m_active;
 	}
 
+	/*!
+	 * Whether the stylus that was processed last is used as an eraser.
+	 *
+	 * This is the debounced state that decides which tool is reported, not the raw bit.
+	 *
+	 * @return true if the stylus is an eraser.
+	 */
+	[[nodiscard]] bool rubber() const
+	{
+		if (!m_serial.has_value())
+			return false;
+
+		const auto it = m_styli.find(m_serial.value());
+		return it != m_styli.end() && it->second.rubber;
+	}
+
+	/*!
+	 * The position of the stylus that was emitted last.
+	 *
+	 * @return The normalized position, or nothing if the stylus is not in proximity.
+	 */
+	[[nodiscard]] std::optional<Vector2<f64>> position() const
+	{
+		return m_position;
+	}
+
 private:
 	/*!
 	 * Calculates the tilt of the stylus on X and Y axis.
 	 *
 	 * @param[in] altitude The altitude of the stylus.
 	 * @param[in] azimuth The azimuth of the stylus.
-	 * @return A Vector containing the tilt on the X and Y axis.
+	 * @return A Vector containing the tilt on the X and Y axis, in radians.
 	 */
-	[[nodiscard]] static Vector2<i32> calculate_tilt(const f64 altitude, const f64 azimuth)
+	[[nodiscard]] static Vector2<f64> calculate_tilt(const f64 altitude, const f64 azimuth)
 	{
-		if (altitude <= 0)
-			return Vector2<i32>::Zero();
-
 		const f64 sin_alt = std::sin(altitude);
 		const f64 sin_azm = std::sin(azimuth);
 
@@ -178,21 +582,562 @@ private:
 		const f64 atan_x = std::atan2(cos_alt, sin_alt * cos_azm);
 		const f64 atan_y = std::atan2(cos_alt, sin_alt * sin_azm);
 
-		const i32 tx = 9000 - casts::to<i32>(std::round(atan_x * 4500 / M_PI_4));
-		const i32 ty = casts::to<i32>(std::round(atan_y * 4500 / M_PI_4)) - 9000;
+		return Vector2<f64> {M_PI_2 - atan_x, atan_y - M_PI_2};
+	}
+
+	/*!
+	 * Clamps the altitude of the stylus to the configured range.
+	 *
+	 * When the stylus is (almost) parallel to the screen, the tilt calculation becomes
+	 * unstable and small changes of the azimuth make the tilt jump between the extremes.
+	 *
+	 * @param[in] altitude The altitude of the stylus, in radians.
+	 * @return The clamped altitude of the stylus, in radians.
+	 */
+	[[nodiscard]] f64 clamp_altitude(const f64 altitude) const
+	{
+		const f64 min = m_config.stylus_altitude_min * M_PI / 180;
+		const f64 max = m_config.stylus_altitude_max * M_PI / 180;
+
+		return std::clamp(altitude, min, max);
+	}
+
+	/*!
+	 * Smoothes the tilt of the stylus using an exponential moving average.
+	 *
+	 * The tilt is smoothed on the X and Y axis instead of as altitude and azimuth, so that
+	 * the azimuth can't wrap around. The smoothed tilt never lags behind by more than
+	 * @ref MAX_TILT_LAG, so fast rotations are followed instead of being averaged out.
+	 *
+	 * @param[in,out] state The tracked state of the stylus.
+	 * @param[in] tilt The measured tilt of the stylus, in radians.
+	 * @return The smoothed tilt of the stylus.
+	 */
+	[[nodiscard]] Vector2<f64> smooth_tilt(State &state, const Vector2<f64> &tilt) const
+	{
+		const f64 factor = m_config.stylus_tilt_smoothing;
+
+		if (!state.tilt.has_value() || factor == 0) {
+			state.tilt = tilt;
+			return tilt;
+		}
+
+		Vector2<f64> smoothed = factor * state.tilt.value() + (1 - factor) * tilt;
+
+		const Vector2<f64> lag = smoothed - tilt;
+		const f64 distance = std::hypot(lag.x(), lag.y());
+
+		if (distance > MAX_TILT_LAG)
+			smoothed = tilt + lag * (MAX_TILT_LAG / distance);
+
+		state.tilt = smoothed;
+		return smoothed;
+	}
+
+	/*!
+	 * Scales the tilt of the stylus to the range of the tilt axes.
+	 *
+	 * @param[in] tilt The tilt on the X and Y axis, in radians.
+	 * @return The tilt in the units of the tilt axes.
+	 */
+	[[nodiscard]] Vector2<i32> scale_tilt(const Vector2<f64> &tilt) const
+	{
+		// A tilt of 90 degrees is mapped onto the configured maximum.
+		const i32 max = m_config.stylus_tilt_max;
+		const f64 scale = max / M_PI_2;
+
+		const i32 tx = casts::to<i32>(std::round(tilt.x() * scale));
+		const i32 ty = casts::to<i32>(std::round(tilt.y() * scale));
+
+		// Out of spec altitude values would exceed the advertised range.
+		return Vector2<i32> {std::clamp(tx, -max, max), std::clamp(ty, -max, max)};
+	}
+
+	/*!
+	 * Snaps the position of the stylus to the configured grid, if snapping is active.
+	 *
+	 * @param[in] position The normalized position of the stylus.
+	 * @return The normalized position of the closest grid lines.
+	 */
+	[[nodiscard]] Vector2<f64> snap_position(const Vector2<f64> &position)
+	{
+		const f64 grid = m_config.stylus_snap_grid;
+
+		// Without a toggle button, the grid is always active.
+		if (grid <= 0 || (m_config.stylus_snap_button && !m_snap)) {
+			m_snapped.reset();
+			return position;
+		}
+
+		const f64 width = m_config.output_width();
+		const f64 height = m_config.output_height();
+
+		const f64 x = position.x() * width;
+		const f64 y = position.y() * height;
+
+		Vector2<f64> snapped {std::round(x / grid) * grid, std::round(y / grid) * grid};
+
+		if (m_snapped.has_value()) {
+			const Vector2<f64> &last = m_snapped.value();
+
+			snapped.x() = StylusDevice::snap_axis(x, last.x(), snapped.x(), grid);
+			snapped.y() = StylusDevice::snap_axis(y, last.y(), snapped.y(), grid);
+		}
+
+		m_snapped = snapped;
+
+		// The last grid line can be outside of the screen.
+		const Vector2<f64> normalized {snapped.x() / width, snapped.y() / height};
+		return StylusDevice::clamp_position(normalized);
+	}
+
+	/*!
+	 * Decides whether the stylus stays on its grid line or moves to the closest one.
+	 *
+	 * @param[in] value The position of the stylus on one axis, in centimeters.
+	 * @param[in] last The grid line the stylus was snapped to before.
+	 * @param[in] closest The grid line that is closest to the stylus.
+	 * @param[in] grid The spacing of the grid lines.
+	 * @return The grid line the stylus is snapped to.
+	 */
+	[[nodiscard]] static f64
+	snap_axis(const f64 value, const f64 last, const f64 closest, const f64 grid)
+	{
+		if (std::abs(value - last) < grid * (0.5 + SNAP_HYSTERESIS))
+			return last;
+
+		return closest;
+	}
+
+	/*!
+	 * Maps the position of the stylus onto the output region of the screen.
+	 *
+	 * @param[in] position The normalized position of the stylus on the digitizer.
+	 * @return The normalized position on the screen.
+	 */
+	[[nodiscard]] Vector2<f64> map_output(const Vector2<f64> &position) const
+	{
+		const f64 left = m_config.stylus_output_left;
+		const f64 top = m_config.stylus_output_top;
+
+		const f64 x = left + position.x() * (m_config.stylus_output_right - left);
+		const f64 y = top + position.y() * (m_config.stylus_output_bottom - top);
+
+		return Vector2<f64> {x, y};
+	}
+
+	/*!
+	 * Decides whether the stylus is used as an eraser.
+	 *
+	 * The first report in proximity decides the tool. After that, the stylus only switches
+	 * tools once it reported the other one for the configured number of reports in a row,
+	 * so a flickering bit doesn't make it switch back and forth.
+	 *
+	 * @param[in,out] state The tracked state of the stylus.
+	 * @param[in] data The current state of the stylus.
+	 * @return Whether the stylus is used as an eraser.
+	 */
+	[[nodiscard]] bool debounce_rubber(State &state, const ipts::StylusData &data) const
+	{
+		// Reports outside of proximity don't tell anything about the tool.
+		if (!data.proximity)
+			return state.rubber;
+
+		if (!state.rubber_known) {
+			state.rubber = data.rubber;
+			state.rubber_known = true;
+		}
+
+		if (data.rubber == state.rubber) {
+			state.rubber_reports = 0;
+			return state.rubber;
+		}
+
+		state.rubber_reports++;
+
+		if (state.rubber_reports > m_config.stylus_rubber_debounce) {
+			state.rubber = data.rubber;
+			state.rubber_reports = 0;
+		}
+
+		return state.rubber;
+	}
+
+	/*!
+	 * Smoothes the position of the stylus using an exponential moving average.
+	 *
+	 * Every stylus is smoothed on its own, so a new pen doesn't start at the last position
+	 * of the previous one.
+	 *
+	 * @param[in,out] state The tracked state of the stylus.
+	 * @param[in] data The current state of the stylus.
+	 * @return The smoothed position of the stylus.
+	 */
+	[[nodiscard]] Vector2<f64> smooth_position(State &state, const ipts::StylusData &data) const
+	{
+		const Vector2<f64> current {data.x, data.y};
+		const f64 factor = m_config.stylus_smoothing;
+
+		if (!state.smoothed.has_value() || factor == 0)
+			state.smoothed = current;
+		else
+			state.smoothed = factor * state.smoothed.value() + (1 - factor) * current;
+
+		return state.smoothed.value();
+	}
+
+	/*!
+	 * Predicts where the stylus will be, based on its current velocity.
+	 *
+	 * @param[in,out] state The tracked state of the stylus.
+	 * @param[in] position The measured position of the stylus.
+	 * @param[in] entered Whether the stylus just came into proximity.
+	 * @return The predicted position, or the measured one if prediction is disabled.
+	 */
+	[[nodiscard]] Vector2<f64> predict_position(State &state,
+	                                            const Vector2<f64> &position,
+	                                            const bool entered) const
+	{
+		// Reports can arrive in bursts, so the elapsed time is taken from their timestamps.
+		const f64 ticks = casts::to<f64>(state.timestamp - state.measured);
+		const f64 elapsed = ticks * m_config.stylus_timestamp_unit / 1e6;
+
+		Vector2<f64> velocity = Vector2<f64>::Zero();
+
+		if (!entered && elapsed > 0)
+			velocity = (position - state.position) / elapsed;
+
+		// Don't shoot off in the old direction when the stylus turns around.
+		const bool reversed = velocity.dot(state.velocity) < 0;
+
+		state.position = position;
+		state.velocity = velocity;
+		state.measured = state.timestamp;
+
+		if (m_config.stylus_prediction <= 0 || reversed)
+			return position;
+
+		const f64 horizon = m_config.stylus_prediction / 1000;
+
+		// The offset in centimeters, to be able to limit the physical distance.
+		Vector2<f64> offset = velocity * horizon;
+		offset.x() *= m_config.output_width();
+		offset.y() *= m_config.output_height();
+
+		const f64 distance = offset.norm();
+		const f64 max = m_config.stylus_prediction_max_distance;
+
+		if (distance > max)
+			offset *= max / distance;
+
+		offset.x() /= m_config.output_width();
+		offset.y() /= m_config.output_height();
+
+		return position + offset;
+	}
+
+	/*!
+	 * Clamps the position of the stylus to the range of the axes.
+	 *
+	 * Without this, a single coordinate that is out of range makes the cursor jump to the
+	 * far end of the axis.
+	 *
+	 * @param[in] position The normalized position of the stylus.
+	 * @return The position, clamped to the range [0, 1].
+	 */
+	[[nodiscard]] static Vector2<f64> clamp_position(const Vector2<f64> &position)
+	{
+		Vector2<f64> clamped = position;
+
+		clamped.x() = std::clamp(clamped.x(), 0.0, 1.0);
+		clamped.y() = std::clamp(clamped.y(), 0.0, 1.0);
+
+		if (clamped != position)
+			spdlog::debug("Clamping stylus position {}/{}", position.x(), position.y());
+
+		return clamped;
+	}
+
+	/*!
+	 * The timestamp that is emitted for the current state of the stylus.
+	 *
+	 * @param[in] data The current state of the stylus.
+	 * @param[in] state The tracked state of the stylus.
+	 * @return The raw 16 bit timestamp, or the extended one if enabled.
+	 */
+	[[nodiscard]] i32 timestamp(const ipts::StylusData &data, const State &state) const
+	{
+		if (m_config.stylus_raw_timestamp)
+			return data.timestamp;
+
+		// ABS_MISC is signed, so wrap around before the value turns negative.
+		return casts::to<i32>(state.timestamp & INT_MAX);
+	}
+
+	/*!
+	 * The timestamp that is emitted through MSC_TIMESTAMP.
+	 *
+	 * The unit of the stylus timestamp is not known, so unless it is configured, the time at
+	 * which the report was received is used instead.
+	 *
+	 * @param[in] state The tracked state of the stylus.
+	 * @return The time of the report in microseconds, wrapping around like the kernel expects.
+	 */
+	[[nodiscard]] i32 msc_timestamp(const State &state) const
+	{
+		const f64 unit = m_config.stylus_timestamp_unit;
+		u64 time = 0;
+
+		if (unit > 0) {
+			time = casts::to<u64>(std::round(state.timestamp * unit));
+		} else {
+			const auto elapsed = chrono::steady_clock::now() - m_created;
+			time = casts::to<u64>(microseconds<f64> {elapsed}.count());
+		}
+
+		return casts::to<i32>(time & INT_MAX);
+	}
 
-		return Vector2<i32> {tx, ty};
+	/*!
+	 * Maps the pressure of the stylus onto the configured pressure curve.
+	 *
+	 * @param[in] pressure The normalized pressure of the stylus.
+	 * @return The adjusted pressure, in the range [0, 1].
+	 */
+	[[nodiscard]] f64 apply_pressure_curve(const f64 pressure) const
+	{
+		// Don't let the curve turn a hovering stylus into a touching one.
+		if (pressure <= 0)
+			return 0;
+
+		const f64 min = m_config.stylus_pressure_min;
+		const f64 max = m_config.stylus_pressure_max;
+
+		const f64 scaled = std::clamp((pressure - min) / (max - min), 0.0, 1.0);
+
+		if (scaled <= 0)
+			return 0;
+
+		const f64 curved = std::pow(scaled, m_config.stylus_pressure_gamma);
+
+		// Pens with dead travel need a light line as soon as they report any pressure.
+		const f64 offset = m_config.stylus_pressure_offset;
+		return offset + (curved * (1 - offset));
+	}
+
+	/*!
+	 * Maps a normalized pressure onto the range of the pressure axis.
+	 *
+	 * @param[in] pressure The pressure of the stylus, in the range [0, 1].
+	 * @return The value that is emitted for the pressure axis.
+	 */
+	[[nodiscard]] i32 scale_pressure(const f64 pressure) const
+	{
+		const f64 min = m_config.stylus_output_pressure_min;
+		const f64 max = m_config.stylus_output_pressure_max;
+
+		return casts::to<i32>(std::round(min + (pressure * (max - min))));
+	}
+
+	/*!
+	 * Emits the state of the barrel button.
+	 *
+	 * If double presses or holds are enabled, a press is held back until it is known whether
+	 * it is followed by a second one, or whether the button is held down.
+	 *
+	 * @param[in] pressed Whether the barrel button is currently pressed.
+	 */
+	void update_button(const bool pressed)
+	{
+		const u16 key = m_config.stylus_key(core::StylusBit::Button);
+		const u16 double_key = m_config.stylus_button_double_key;
+
+		// The button is used for switching snapping on and off, and is not emitted at all.
+		if (m_config.stylus_snap_button) {
+			if (pressed && !m_button)
+				m_snap = !m_snap;
+
+			m_button = pressed;
+			return;
+		}
+
+		if (double_key == 0 && m_config.stylus_button_hold_key == 0) {
+			m_emitter->emit(EV_KEY, key, pressed ? 1 : 0);
+			return;
+		}
+
+		// A press whose window or hold time ran out before this report.
+		this->expire_button();
+
+		if (pressed && !m_button) {
+			if (m_button_pending.has_value()) {
+				m_button_pending.reset();
+				m_button_key = m_config.stylus_button_double_key;
+
+				m_emitter->emit(EV_KEY, m_button_key.value(), 1);
+			} else {
+				m_button_pending = chrono::steady_clock::now();
+			}
+		}
+
+		if (!pressed && m_button_key.has_value()) {
+			m_emitter->emit(EV_KEY, m_button_key.value(), 0);
+			m_button_key.reset();
+		}
+
+		m_button = pressed;
+
+		// Without double presses, a short press is known as soon as the button is released.
+		if (!pressed && m_button_pending.has_value() && double_key == 0) {
+			m_button_pending.reset();
+
+			m_emitter->emit(EV_KEY, key, 1);
+			this->sync();
+			m_emitter->emit(EV_KEY, key, 0);
+		}
+	}
+
+	/*!
+	 * Holds back a report if it arrives too early for the configured maximum rate.
+	 *
+	 * Only the latest report is kept, the ones before it are dropped. Reports that change the
+	 * state of the stylus (proximity, contact, buttons or eraser) are never held back.
+	 *
+	 * @param[in] data The current state of the stylus.
+	 * @return Whether the report was held back.
+	 */
+	bool coalesce(const ipts::StylusData &data)
+	{
+		if (m_config.stylus_max_rate == 0 || !m_emitted.has_value())
+			return false;
+
+		const ipts::StylusData &last = m_emitted.value();
+
+		bool changed = data.serial != last.serial;
+		changed |= data.proximity != last.proximity || data.contact != last.contact;
+		changed |= data.button != last.button || data.rubber != last.rubber;
+
+		if (changed)
+			return false;
+
+		const seconds<f64> interval {1.0 / casts::to<f64>(m_config.stylus_max_rate)};
+
+		if (chrono::steady_clock::now() - m_emitted_time >= interval)
+			return false;
+
+		m_pending = data;
+		m_last_report = chrono::steady_clock::now();
+
+		return true;
+	}
+
+	/*!
+	 * Emits a pending press of the barrel button, if its window or hold time is over.
+	 *
+	 * A press that is held for longer than the hold time engages the hold key right away.
+	 * Otherwise, once the window for a double press is over, the button key stays pressed
+	 * if the button is still held, or is clicked if it is not.
+	 *
+	 * @return Whether any events were emitted.
+	 */
+	bool expire_button()
+	{
+		if (!m_button_pending.has_value())
+			return false;
+
+		const auto elapsed = chrono::steady_clock::now() - m_button_pending.value();
+
+		if (m_config.stylus_button_hold_key != 0 && m_button) {
+			const milliseconds<usize> hold {m_config.stylus_button_hold_time};
+
+			// Until it is released, the press can still turn into a hold.
+			if (elapsed < hold)
+				return false;
+
+			m_button_pending.reset();
+			m_button_key = m_config.stylus_button_hold_key;
+
+			m_emitter->emit(EV_KEY, m_button_key.value(), 1);
+			return true;
+		}
+
+		const auto window =
+			casts::to<chrono::milliseconds::rep>(m_config.stylus_button_double_window);
+
+		if (m_config.stylus_button_double_key == 0)
+			return false;
+
+		if (elapsed < chrono::milliseconds {window})
+			return false;
+
+		const u16 key = m_config.stylus_key(core::StylusBit::Button);
+
+		m_button_pending.reset();
+		m_emitter->emit(EV_KEY, key, 1);
+
+		if (m_button) {
+			m_button_key = key;
+		} else {
+			this->sync();
+			m_emitter->emit(EV_KEY, key, 0);
+		}
+
+		return true;
 	}
 
 	/*!
 	 * Lifts the stylus input.
 	 */
-	void lift() const
+	void lift()
+	{
+		m_contact = false;
+
+		m_emitter->emit(EV_KEY, BTN_TOUCH, 0);
+		m_emitter->emit(EV_KEY, BTN_TOOL_PEN, 0);
+		m_emitter->emit(EV_KEY, BTN_TOOL_RUBBER, 0);
+		m_emitter->emit(EV_KEY, m_config.stylus_key(core::StylusBit::Button), 0);
+
+		if (m_config.stylus_key(core::StylusBit::Rubber) != 0)
+			m_emitter->emit(EV_KEY, m_config.stylus_key(core::StylusBit::Rubber), 0);
+
+		if (m_config.stylus_button_double_key != 0)
+			m_emitter->emit(EV_KEY, m_config.stylus_button_double_key, 0);
+
+		if (m_config.stylus_button_hold_key != 0)
+			m_emitter->emit(EV_KEY, m_config.stylus_button_hold_key, 0);
+
+		if (m_config.stylus_multitouch) {
+			m_emitter->emit(EV_ABS, ABS_MT_SLOT, 0);
+			m_emitter->emit(EV_ABS, ABS_MT_TRACKING_ID, -1);
+		}
+
+		// Presses that were not emitted yet are dropped.
+		m_button = false;
+		m_button_pending.reset();
+		m_button_key.reset();
+
+		m_snapped.reset();
+	}
+
+	/*!
+	 * Forgets the state of the current stylus that only lasts while it is in proximity.
+	 *
+	 * However proximity ended, the first reports are discarded again when the stylus returns,
+	 * and its tool and tilt start over.
+	 */
+	void leave_proximity()
 	{
-		m_uinput->emit(EV_KEY, BTN_TOUCH, 0);
-		m_uinput->emit(EV_KEY, BTN_TOOL_PEN, 0);
-		m_uinput->emit(EV_KEY, BTN_TOOL_RUBBER, 0);
-		m_uinput->emit(EV_KEY, BTN_STYLUS, 0);
+		if (!m_serial.has_value())
+			return;
+
+		State &state = m_styli[m_serial.value()];
+
+		state.discarded = 0;
+		state.smoothed.reset();
+		state.tilt.reset();
+		state.rubber = false;
+		state.rubber_reports = 0;
+		state.rubber_known = false;
 	}
 
 	/*!
@@ -200,7 +1145,7 @@ private:
 	 */
 	void sync() const
 	{
-		m_uinput->emit(EV_SYN, SYN_REPORT, 0);
+		m_emitter->emit(EV_SYN, SYN_REPORT, 0);
 	}
 };
 
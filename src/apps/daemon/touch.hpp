@@ -3,7 +3,7 @@
 #ifndef IPTSD_APPS_DAEMON_TOUCH_HPP
 #define IPTSD_APPS_DAEMON_TOUCH_HPP
 
-#include "uinput-device.hpp"
+#include "emitter.hpp"
 
 #include <common/casts.hpp>
 #include <common/types.hpp>
@@ -12,15 +12,19 @@
 #include <core/generic/device.hpp>
 
 #include <gsl/gsl>
+#include <spdlog/spdlog.h>
 
 #include <linux/input-event-codes.h>
+#include <linux/input.h>
 
 #include <algorithm>
 #include <cmath>
 #include <iterator>
+#include <map>
 #include <memory>
 #include <optional>
 #include <set>
+#include <utility>
 #include <vector>
 
 namespace iptsd::apps::daemon {
@@ -37,12 +41,23 @@ private:
 	 */
 	constexpr static usize DIAGONAL = 12000;
 
+	/*
+	 * How far the smoothed position of a contact may lag behind the measured one,
+	 * in centimeters. This keeps smoothing from slowing down quick flicks.
+	 */
+	constexpr static f64 MAX_SMOOTHING_LAG = 0.2;
+
 private:
-	std::shared_ptr<UinputDevice> m_uinput = std::make_shared<UinputDevice>();
+	// Where the input events are sent to.
+	std::shared_ptr<Emitter> m_emitter;
 
 	// The daemon configuration.
 	core::Config m_config;
 
+	// The maximum coordinates, after the axes were rotated like the screen.
+	i32 m_max_x = MAX_X;
+	i32 m_max_y = MAX_Y;
+
 	// The indices of the contacts in the current frame.
 	std::set<usize> m_current {};
 
@@ -52,43 +67,86 @@ private:
 	// The difference between m_last and m_current.
 	std::set<usize> m_lift {};
 
+	// For how many frames every current contact has been present.
+	std::map<usize, usize> m_lifetime {};
+
+	// The smoothed position of every current contact.
+	std::map<usize, Vector2<f64>> m_smoothed {};
+
 	// The index of the contact that is emitted through the singletouch API.
 	usize m_single_index = 0;
 
 	// Whether the device is enabled.
 	bool m_enabled = true;
 
+	// Whether a hand is resting on the display, until all contacts are lifted.
+	bool m_palm = false;
+
 public:
-	TouchDevice(const core::Config &config, const core::DeviceInfo &info) : m_config {config}
+	TouchDevice(const core::Config &config,
+	            const core::DeviceInfo &info,
+	            std::shared_ptr<Emitter> emitter)
+		: m_emitter {std::move(emitter)},
+		  m_config {config}
 	{
-		m_uinput->set_name("IPTS Touch");
-		m_uinput->set_vendor(info.vendor);
-		m_uinput->set_product(info.product);
+		u16 vendor = info.vendor;
+		u16 product = info.product;
+
+		// Configured IDs override the ones that were inherited from the IPTS device.
+		if (config.uinput_vendor != 0)
+			vendor = config.uinput_vendor;
+
+		if (config.uinput_product != 0)
+			product = config.uinput_product;
+
+		m_emitter->set_name(config.uinput_touch_name);
+		m_emitter->set_vendor(vendor);
+		m_emitter->set_product(product);
+		m_emitter->set_version(config.uinput_version);
 
-		m_uinput->set_evbit(EV_ABS);
-		m_uinput->set_evbit(EV_KEY);
+		m_emitter->set_evbit(EV_ABS);
+		m_emitter->set_evbit(EV_KEY);
 
-		m_uinput->set_propbit(INPUT_PROP_DIRECT);
-		m_uinput->set_keybit(BTN_TOUCH);
+		m_emitter->set_propbit(INPUT_PROP_DIRECT);
+		m_emitter->set_keybit(BTN_TOUCH);
+
+		if (config.swaps_axes())
+			std::swap(m_max_x, m_max_y);
+
+		// Map the coordinates onto the pixels of the screen, if its resolution is known.
+		if (config.screen_width > 0 && config.screen_height > 0) {
+			m_max_x = casts::to<i32>(config.screen_width - 1);
+			m_max_y = casts::to<i32>(config.screen_height - 1);
+		}
 
 		const f64 diag = std::hypot(config.width, config.height);
 
+		const f64 width = config.output_width();
+		const f64 height = config.output_height();
+
 		// Resolution for X / Y is expected to be units/mm.
-		const i32 res_x = casts::to<i32>(std::round(MAX_X / (config.width * 10)));
-		const i32 res_y = casts::to<i32>(std::round(MAX_Y / (config.height * 10)));
+		const i32 res_x = casts::to<i32>(std::round(m_max_x / (width * 10)));
+		const i32 res_y = casts::to<i32>(std::round(m_max_y / (height * 10)));
 		const i32 res_d = casts::to<i32>(std::round(DIAGONAL / (diag * 10)));
 
-		m_uinput->set_absinfo(ABS_MT_SLOT, 0, MAX_CONTACTS, 0);
-		m_uinput->set_absinfo(ABS_MT_TRACKING_ID, 0, MAX_CONTACTS, 0);
-		m_uinput->set_absinfo(ABS_MT_POSITION_X, 0, MAX_X, res_x);
-		m_uinput->set_absinfo(ABS_MT_POSITION_Y, 0, MAX_Y, res_y);
-		m_uinput->set_absinfo(ABS_MT_ORIENTATION, 0, 180, 0);
-		m_uinput->set_absinfo(ABS_MT_TOUCH_MAJOR, 0, DIAGONAL, res_d);
-		m_uinput->set_absinfo(ABS_MT_TOUCH_MINOR, 0, DIAGONAL, res_d);
-		m_uinput->set_absinfo(ABS_X, 0, MAX_X, res_x);
-		m_uinput->set_absinfo(ABS_Y, 0, MAX_Y, res_y);
-
-		m_uinput->create();
+		m_emitter->set_absinfo(ABS_MT_SLOT, 0, MAX_CONTACTS, 0);
+		m_emitter->set_absinfo(ABS_MT_TRACKING_ID, 0, MAX_CONTACTS, 0);
+		m_emitter->set_absinfo(ABS_MT_POSITION_X, 0, m_max_x, res_x);
+		m_emitter->set_absinfo(ABS_MT_POSITION_Y, 0, m_max_y, res_y);
+		m_emitter->set_absinfo(ABS_MT_ORIENTATION, 0, 180, 0);
+		m_emitter->set_absinfo(ABS_MT_TOUCH_MAJOR, 0, DIAGONAL, res_d);
+		m_emitter->set_absinfo(ABS_MT_TOUCH_MINOR, 0, DIAGONAL, res_d);
+
+		if (config.touch_pressure)
+			m_emitter->set_absinfo(ABS_MT_PRESSURE, 0, config.touch_pressure_max, 0);
+
+		if (config.touch_report_palms)
+			m_emitter->set_absinfo(ABS_MT_TOOL_TYPE, 0, MT_TOOL_MAX, 0);
+
+		m_emitter->set_absinfo(ABS_X, 0, m_max_x, res_x);
+		m_emitter->set_absinfo(ABS_Y, 0, m_max_y, res_y);
+
+		m_emitter->create();
 	}
 
 	/*!
@@ -105,14 +163,29 @@ public:
 		// Find the inputs that need to be lifted
 		this->search_lifted(contacts);
 
-		if (this->is_blocked(contacts))
+		const bool palm = this->is_palm(contacts);
+
+		// Palms can be reported to the system instead of being dropped.
+		if (this->is_blocked(contacts) || (palm && !m_config.touch_report_palms))
 			this->lift_all();
 		else
-			this->process(contacts);
+			this->process(contacts, palm);
 
 		this->sync();
 	}
 
+	/*!
+	 * Applies a reloaded configuration to the device.
+	 *
+	 * The axes of the uinput device stay the same until it is recreated.
+	 *
+	 * @param[in] config The new daemon configuration.
+	 */
+	void reload(const core::Config &config)
+	{
+		m_config = config;
+	}
+
 	/*!
 	 * Disables the touchscreen and lifts all contacts.
 	 */
@@ -127,6 +200,8 @@ public:
 		m_current.clear();
 		m_last.clear();
 		m_lift.clear();
+		m_lifetime.clear();
+		m_smoothed.clear();
 	}
 
 	/*!
@@ -186,6 +261,15 @@ private:
 		                    m_current.cbegin(),
 		                    m_current.cend(),
 		                    std::inserter(m_lift, m_lift.begin()));
+
+		// The index can be reused by a new contact, which must not continue the old one.
+		for (const usize index : m_lift) {
+			m_lifetime.erase(index);
+			m_smoothed.erase(index);
+		}
+
+		for (const usize index : m_current)
+			m_lifetime[index]++;
 	}
 
 	/*!
@@ -204,36 +288,89 @@ private:
 		});
 	}
 
+	/*!
+	 * Checks if the touchscreen should be disabled because a whole hand is on the screen.
+	 *
+	 * Once this happened, the touchscreen stays disabled until all contacts are lifted.
+	 *
+	 * @param[in] contacts All currently active contacts.
+	 * @return true if all contacts should be lifted.
+	 */
+	[[nodiscard]] bool is_palm(const std::vector<contacts::Contact<f64>> &contacts)
+	{
+		if (m_palm) {
+			m_palm = !contacts.empty();
+			return m_palm;
+		}
+
+		const usize max_contacts = m_config.touch_palm_max_contacts;
+		const f64 max_area = m_config.touch_palm_max_area;
+
+		if (max_contacts == 0 && max_area <= 0)
+			return false;
+
+		const f64 diagonal = std::hypot(m_config.width, m_config.height);
+
+		// The contacts are ellipses, with their size given as the diameters of the axes.
+		f64 area = 0;
+		for (const contacts::Contact<f64> &contact : contacts) {
+			const Vector2<f64> size = contact.size * diagonal;
+			area += M_PI / 4 * size.x() * size.y();
+		}
+
+		const bool count = max_contacts > 0 && contacts.size() > max_contacts;
+		const bool large = max_area > 0 && area > max_area;
+
+		if (!count && !large)
+			return false;
+
+		spdlog::info("Rejecting palm: {} contacts, {:.1f} cm²", contacts.size(), area);
+
+		m_palm = true;
+		return true;
+	}
+
 	/*!
 	 * Emits linux multitouch events for every contact.
 	 *
 	 * @param[in] contacts All currently active contacts.
+	 * @param[in] palm Whether a hand is resting on the display.
 	 */
-	void process(const std::vector<contacts::Contact<f64>> &contacts)
+	void process(const std::vector<contacts::Contact<f64>> &contacts, const bool palm)
 	{
 		bool reset_singletouch = true;
 
-		const f64 ox = m_config.touch_overshoot / m_config.width;
-		const f64 oy = m_config.touch_overshoot / m_config.height;
+		const f64 ox = m_config.touch_overshoot / m_config.output_width();
+		const f64 oy = m_config.touch_overshoot / m_config.output_height();
 
-		for (const contacts::Contact<f64> &contact : contacts) {
+		for (const contacts::Contact<f64> &raw : contacts) {
 			// Ignore contacts without an index
-			if (!contact.index.has_value())
+			if (!raw.index.has_value())
 				continue;
 
-			const usize index = contact.index.value();
+			const usize index = raw.index.value();
 
 			// Ignore unstable changes
-			if (!contact.stable.value_or(true))
+			if (!raw.stable.value_or(true))
+				continue;
+
+			// Blobs that only show up for a moment are noise, not taps.
+			if (m_lifetime[index] < m_config.touch_min_lifetime)
 				continue;
 
+			contacts::Contact<f64> contact = raw;
+			contact.mean = this->smooth_position(index, raw.mean);
+
+			// Contacts that look like a palm are lifted, unless palms are reported.
+			const bool invalid = palm || !contact.valid.value_or(true);
+
 			// Check if the contact is too far outside of the screen.
-			bool lift = !contact.valid.value_or(true);
+			bool lift = invalid && !m_config.touch_report_palms;
 			lift |= contact.mean.x() < -ox || contact.mean.x() > (ox + 1);
 			lift |= contact.mean.y() < -oy || contact.mean.y() > (oy + 1);
 
 			if (!lift)
-				this->emit_multitouch(contact);
+				this->emit_multitouch(contact, invalid);
 			else
 				this->lift_multitouch(index);
 
@@ -241,7 +378,7 @@ private:
 			if (m_single_index != index)
 				continue;
 
-			if (!lift) {
+			if (!lift && !invalid) {
 				this->emit_singletouch(contact);
 				reset_singletouch = false;
 			}
@@ -273,21 +410,61 @@ private:
 		}
 	}
 
+	/*!
+	 * Smoothes the position of a contact using an exponential moving average.
+	 *
+	 * The smoothed position never lags behind by more than @ref MAX_SMOOTHING_LAG, so that
+	 * slow movements become smoother, but fast ones are followed immediately.
+	 *
+	 * @param[in] index The index of the contact.
+	 * @param[in] position The measured position of the contact.
+	 * @return The smoothed position of the contact.
+	 */
+	[[nodiscard]] Vector2<f64> smooth_position(const usize index, const Vector2<f64> &position)
+	{
+		const f64 factor = m_config.touch_smoothing;
+		const auto it = m_smoothed.find(index);
+
+		if (it == m_smoothed.end() || factor == 0) {
+			m_smoothed[index] = position;
+			return position;
+		}
+
+		Vector2<f64> smoothed = factor * it->second + (1 - factor) * position;
+
+		// Measure the lag in centimeters, the axes have different lengths.
+		const f64 lag_x = (smoothed.x() - position.x()) * m_config.output_width();
+		const f64 lag_y = (smoothed.y() - position.y()) * m_config.output_height();
+		const f64 lag = std::hypot(lag_x, lag_y);
+
+		if (lag > MAX_SMOOTHING_LAG)
+			smoothed = position + (smoothed - position) * (MAX_SMOOTHING_LAG / lag);
+
+		it->second = smoothed;
+		return smoothed;
+	}
+
 	/*!
 	 * Emits a lift event using the linux multitouch protocol.
+	 *
+	 * The slot is selected before the tracking ID is cleared, so that the lift always ends up
+	 * in the slot of the contact, even if other slots were updated in the same frame.
+	 *
+	 * @param[in] index The slot of the contact that was lifted.
 	 */
 	void lift_multitouch(const usize index) const
 	{
-		m_uinput->emit(EV_ABS, ABS_MT_SLOT, casts::to<i32>(index));
-		m_uinput->emit(EV_ABS, ABS_MT_TRACKING_ID, -1);
+		m_emitter->emit(EV_ABS, ABS_MT_SLOT, casts::to<i32>(index));
+		m_emitter->emit(EV_ABS, ABS_MT_TRACKING_ID, -1);
 	}
 
 	/*!
 	 * Emits a contact event using the linux multitouch protocol.
 	 *
 	 * @param[in] contact The contact to emit.
+	 * @param[in] palm Whether the contact is reported as a palm.
 	 */
-	void emit_multitouch(const contacts::Contact<f64> &contact) const
+	void emit_multitouch(const contacts::Contact<f64> &contact, const bool palm) const
 	{
 		const Vector2<f64> size = contact.size;
 
@@ -298,21 +475,36 @@ private:
 
 		const i32 index = casts::to<i32>(contact.index.value_or(0));
 
-		const i32 x = casts::to<i32>(std::round(mean.x() * MAX_X));
-		const i32 y = casts::to<i32>(std::round(mean.y() * MAX_Y));
+		const i32 x = casts::to<i32>(std::round(mean.x() * m_max_x));
+		const i32 y = casts::to<i32>(std::round(mean.y() * m_max_y));
 
 		const i32 angle = casts::to<i32>(std::round(contact.orientation * 180));
 		const i32 major = casts::to<i32>(std::round(size.maxCoeff() * DIAGONAL));
 		const i32 minor = casts::to<i32>(std::round(size.minCoeff() * DIAGONAL));
 
-		m_uinput->emit(EV_ABS, ABS_MT_SLOT, index);
-		m_uinput->emit(EV_ABS, ABS_MT_TRACKING_ID, index);
-		m_uinput->emit(EV_ABS, ABS_MT_POSITION_X, x);
-		m_uinput->emit(EV_ABS, ABS_MT_POSITION_Y, y);
+		m_emitter->emit(EV_ABS, ABS_MT_SLOT, index);
+		m_emitter->emit(EV_ABS, ABS_MT_TRACKING_ID, index);
+		m_emitter->emit(EV_ABS, ABS_MT_POSITION_X, x);
+		m_emitter->emit(EV_ABS, ABS_MT_POSITION_Y, y);
+
+		m_emitter->emit(EV_ABS, ABS_MT_ORIENTATION, angle);
+		m_emitter->emit(EV_ABS, ABS_MT_TOUCH_MAJOR, major);
+		m_emitter->emit(EV_ABS, ABS_MT_TOUCH_MINOR, minor);
+
+		if (m_config.touch_report_palms) {
+			const i32 tool = palm ? MT_TOOL_PALM : MT_TOOL_FINGER;
+			m_emitter->emit(EV_ABS, ABS_MT_TOOL_TYPE, tool);
+		}
+
+		if (!m_config.touch_pressure)
+			return;
+
+		// Pressing a finger harder against the screen makes the contact more intense.
+		const f64 pressure = contact.intensity / m_config.touch_pressure_intensity;
+		const f64 max = m_config.touch_pressure_max;
 
-		m_uinput->emit(EV_ABS, ABS_MT_ORIENTATION, angle);
-		m_uinput->emit(EV_ABS, ABS_MT_TOUCH_MAJOR, major);
-		m_uinput->emit(EV_ABS, ABS_MT_TOUCH_MINOR, minor);
+		const i32 scaled = casts::to<i32>(std::round(std::clamp(pressure, 0.0, 1.0) * max));
+		m_emitter->emit(EV_ABS, ABS_MT_PRESSURE, scaled);
 	}
 
 	/*!
@@ -365,7 +557,7 @@ private:
 	 */
 	void lift_singletouch() const
 	{
-		m_uinput->emit(EV_KEY, BTN_TOUCH, 0);
+		m_emitter->emit(EV_KEY, BTN_TOUCH, 0);
 	}
 
 	/*!
@@ -380,12 +572,12 @@ private:
 		mean.x() = std::clamp(mean.x(), 0.0, 1.0);
 		mean.y() = std::clamp(mean.y(), 0.0, 1.0);
 
-		const i32 x = casts::to<i32>(std::round(mean.x() * MAX_X));
-		const i32 y = casts::to<i32>(std::round(mean.y() * MAX_Y));
+		const i32 x = casts::to<i32>(std::round(mean.x() * m_max_x));
+		const i32 y = casts::to<i32>(std::round(mean.y() * m_max_y));
 
-		m_uinput->emit(EV_KEY, BTN_TOUCH, 1);
-		m_uinput->emit(EV_ABS, ABS_X, x);
-		m_uinput->emit(EV_ABS, ABS_Y, y);
+		m_emitter->emit(EV_KEY, BTN_TOUCH, 1);
+		m_emitter->emit(EV_ABS, ABS_X, x);
+		m_emitter->emit(EV_ABS, ABS_Y, y);
 	}
 
 	/*!
@@ -393,15 +585,11 @@ private:
 	 */
 	void lift_all() const
 	{
-		for (const usize &index : m_current) {
-			m_uinput->emit(EV_ABS, ABS_MT_SLOT, casts::to<i32>(index));
+		for (const usize &index : m_current)
 			this->lift_multitouch(index);
-		}
 
-		for (const usize &index : m_last) {
-			m_uinput->emit(EV_ABS, ABS_MT_SLOT, casts::to<i32>(index));
+		for (const usize &index : m_last)
 			this->lift_multitouch(index);
-		}
 
 		this->lift_singletouch();
 	}
@@ -411,7 +599,7 @@ private:
 	 */
 	void sync() const
 	{
-		m_uinput->emit(EV_SYN, SYN_REPORT, 0);
+		m_emitter->emit(EV_SYN, SYN_REPORT, 0);
 	}
 };
 
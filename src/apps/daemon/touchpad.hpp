@@ -0,0 +1,312 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_APPS_DAEMON_TOUCHPAD_HPP
+#define IPTSD_APPS_DAEMON_TOUCHPAD_HPP
+
+#include "emitter.hpp"
+
+#include <common/casts.hpp>
+#include <common/types.hpp>
+#include <contacts/contact.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+
+#include <linux/input-event-codes.h>
+
+#include <algorithm>
+#include <cmath>
+#include <memory>
+#include <optional>
+#include <utility>
+#include <vector>
+
+namespace iptsd::apps::daemon {
+
+/*
+ * A relative pointer device that is controlled by a region of the touchscreen.
+ * Fingers that touch down inside of the region move the pointer like on a touchpad,
+ * all other fingers are passed on to the touchscreen.
+ *
+ * In mouse mode, the whole screen acts as the region, but only while a single finger is
+ * touching it. As soon as a second finger touches down, all fingers go to the touchscreen.
+ */
+class TouchpadDevice {
+private:
+	/*
+	 * How many centimeters a finger can move and still be counted as a tap.
+	 */
+	constexpr static f64 TAP_DISTANCE = 0.2;
+
+private:
+	// Where the input events are sent to.
+	std::shared_ptr<Emitter> m_emitter;
+
+	// The daemon configuration.
+	core::Config m_config;
+
+	// The indices of all contacts that were active in the last frame.
+	std::vector<usize> m_active {};
+
+	// The indices of the contacts that touched down inside of the region.
+	std::vector<usize> m_claimed {};
+
+	// The index of the contact that is moving the pointer.
+	std::optional<usize> m_pointer = std::nullopt;
+
+	// The position of the pointer contact in the last frame, in centimeters.
+	Vector2<f64> m_last = Vector2<f64>::Zero();
+
+	// The movement that was not emitted yet, because it is smaller than one unit.
+	Vector2<f64> m_remainder = Vector2<f64>::Zero();
+
+	// How many centimeters the pointer contact has moved since it touched down.
+	f64 m_travel = 0;
+
+	// The contacts that are not controlling the pointer.
+	std::vector<contacts::Contact<f64>> m_remaining {};
+
+public:
+	TouchpadDevice(const core::Config &config,
+	               const core::DeviceInfo &info,
+	               std::shared_ptr<Emitter> emitter)
+		: m_emitter {std::move(emitter)},
+		  m_config {config}
+	{
+		u16 vendor = info.vendor;
+		u16 product = info.product;
+
+		// Configured IDs override the ones that were inherited from the IPTS device.
+		if (config.uinput_vendor != 0)
+			vendor = config.uinput_vendor;
+
+		if (config.uinput_product != 0)
+			product = config.uinput_product;
+
+		m_emitter->set_name(config.uinput_touchpad_name);
+		m_emitter->set_vendor(vendor);
+		m_emitter->set_product(product);
+		m_emitter->set_version(config.uinput_version);
+
+		m_emitter->set_evbit(EV_KEY);
+		m_emitter->set_evbit(EV_REL);
+
+		m_emitter->set_keybit(BTN_LEFT);
+		m_emitter->set_relbit(REL_X);
+		m_emitter->set_relbit(REL_Y);
+
+		m_emitter->create();
+	}
+
+	/*!
+	 * Moves the pointer with the contacts that touched down inside of the region.
+	 *
+	 * A finger that is lifted again without moving emits a click.
+	 *
+	 * @param[in] contacts All currently active contacts.
+	 * @return The contacts that should be passed on to the touchscreen.
+	 */
+	const std::vector<contacts::Contact<f64>> &
+	update(const std::vector<contacts::Contact<f64>> &contacts)
+	{
+		std::vector<usize> active {};
+		m_remaining.clear();
+
+		const bool multiple = m_config.touch_mouse && TouchpadDevice::count(contacts) > 1;
+
+		// A second finger switches back to the touchscreen, without emitting a click.
+		if (multiple) {
+			m_claimed.clear();
+			m_pointer.reset();
+		}
+
+		for (const contacts::Contact<f64> &contact : contacts) {
+			if (!contact.index.has_value()) {
+				m_remaining.push_back(contact);
+				continue;
+			}
+
+			const usize index = contact.index.value();
+			active.push_back(index);
+
+			// Only new contacts are claimed, fingers can't be dragged into the region.
+			const bool added = !TouchpadDevice::contains(m_active, index);
+
+			if (!multiple && added && this->inside(contact))
+				m_claimed.push_back(index);
+
+			if (!TouchpadDevice::contains(m_claimed, index)) {
+				m_remaining.push_back(contact);
+				continue;
+			}
+
+			const Vector2<f64> position = this->position(contact);
+
+			if (!m_pointer.has_value() && contact.valid.value_or(true)) {
+				m_pointer = index;
+				m_last = position;
+				m_remainder = Vector2<f64>::Zero();
+				m_travel = 0;
+
+				continue;
+			}
+
+			if (m_pointer == index)
+				this->move(position);
+		}
+
+		if (m_pointer.has_value() && !TouchpadDevice::contains(active, m_pointer.value())) {
+			if (m_travel < TAP_DISTANCE)
+				this->click();
+
+			m_pointer.reset();
+		}
+
+		// Forget about the claimed contacts that were lifted.
+		auto lifted = [&](const usize index) {
+			return !TouchpadDevice::contains(active, index);
+		};
+
+		m_claimed.erase(std::remove_if(m_claimed.begin(), m_claimed.end(), lifted),
+		                m_claimed.end());
+
+		m_active = std::move(active);
+		return m_remaining;
+	}
+
+	/*!
+	 * Forgets about all contacts, without emitting a click.
+	 */
+	void reset()
+	{
+		m_active.clear();
+		m_claimed.clear();
+		m_pointer.reset();
+	}
+
+	/*!
+	 * Applies a reloaded configuration to the device.
+	 *
+	 * @param[in] config The new daemon configuration.
+	 */
+	void reload(const core::Config &config)
+	{
+		m_config = config;
+	}
+
+private:
+	/*!
+	 * Checks whether a contact is inside of the touchpad region.
+	 *
+	 * @param[in] contact The contact to check.
+	 * @return Whether the center of the contact is inside of the region.
+	 */
+	[[nodiscard]] bool inside(const contacts::Contact<f64> &contact) const
+	{
+		if (m_config.touch_mouse)
+			return true;
+
+		const f64 x = contact.mean.x();
+		const f64 y = contact.mean.y();
+
+		if (x < m_config.touch_touchpad_left || x > m_config.touch_touchpad_right)
+			return false;
+
+		return y >= m_config.touch_touchpad_top && y <= m_config.touch_touchpad_bottom;
+	}
+
+	/*!
+	 * Calculates the physical position of a contact.
+	 *
+	 * @param[in] contact The contact to convert.
+	 * @return The center of the contact in centimeters.
+	 */
+	[[nodiscard]] Vector2<f64> position(const contacts::Contact<f64> &contact) const
+	{
+		const f64 x = contact.mean.x() * m_config.output_width();
+		const f64 y = contact.mean.y() * m_config.output_height();
+
+		return Vector2<f64> {x, y};
+	}
+
+	/*!
+	 * Moves the pointer by the distance the pointer contact has moved since the last frame.
+	 *
+	 * @param[in] position The new position of the pointer contact in centimeters.
+	 */
+	void move(const Vector2<f64> &position)
+	{
+		const Vector2<f64> delta = position - m_last;
+
+		m_last = position;
+		m_travel += std::hypot(delta.x(), delta.y());
+		m_remainder += delta * m_config.touch_touchpad_speed;
+
+		const i32 x = casts::to<i32>(std::trunc(m_remainder.x()));
+		const i32 y = casts::to<i32>(std::trunc(m_remainder.y()));
+
+		if (x == 0 && y == 0)
+			return;
+
+		m_remainder.x() -= x;
+		m_remainder.y() -= y;
+
+		if (x != 0)
+			m_emitter->emit(EV_REL, REL_X, x);
+
+		if (y != 0)
+			m_emitter->emit(EV_REL, REL_Y, y);
+
+		this->sync();
+	}
+
+	/*!
+	 * Presses and releases the left button.
+	 */
+	void click() const
+	{
+		m_emitter->emit(EV_KEY, BTN_LEFT, 1);
+		this->sync();
+
+		m_emitter->emit(EV_KEY, BTN_LEFT, 0);
+		this->sync();
+	}
+
+	/*!
+	 * Commits the emitted events to the linux kernel.
+	 */
+	void sync() const
+	{
+		m_emitter->emit(EV_SYN, SYN_REPORT, 0);
+	}
+
+	/*!
+	 * Counts the contacts that are tracked, and can therefore be claimed.
+	 *
+	 * @param[in] contacts All currently active contacts.
+	 * @return How many of the contacts have an index.
+	 */
+	[[nodiscard]] static usize count(const std::vector<contacts::Contact<f64>> &contacts)
+	{
+		auto tracked = [](const contacts::Contact<f64> &contact) {
+			return contact.index.has_value();
+		};
+
+		return casts::to<usize>(std::count_if(contacts.cbegin(), contacts.cend(), tracked));
+	}
+
+	/*!
+	 * Checks whether a list of contact indices contains an index.
+	 *
+	 * @param[in] indices The list of indices.
+	 * @param[in] index The index to search for.
+	 * @return Whether the index is in the list.
+	 */
+	[[nodiscard]] static bool contains(const std::vector<usize> &indices, const usize index)
+	{
+		return std::find(indices.cbegin(), indices.cend(), index) != indices.cend();
+	}
+};
+
+} // namespace iptsd::apps::daemon
+
+#endif // IPTSD_APPS_DAEMON_TOUCHPAD_HPP
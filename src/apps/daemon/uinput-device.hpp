@@ -3,22 +3,27 @@
 #ifndef IPTSD_APPS_DAEMON_UINPUT_DEVICE_HPP
 #define IPTSD_APPS_DAEMON_UINPUT_DEVICE_HPP
 
+#include "emitter.hpp"
+
 #include <common/types.hpp>
 #include <core/linux/syscalls.hpp>
 
 #include <linux/input.h>
 #include <linux/uinput.h>
 
+#include <gsl/gsl>
+
 #include <exception>
 #include <fcntl.h>
 #include <string>
 #include <utility>
+#include <vector>
 
 namespace syscalls = iptsd::core::linux::syscalls;
 
 namespace iptsd::apps::daemon {
 
-class UinputDevice {
+class UinputDevice : public Emitter {
 private:
 	std::string m_name;
 	u16 m_vendor = 0;
@@ -28,10 +33,22 @@ private:
 	// The file descriptor of the open uinput node.
 	int m_fd;
 
+	// The events that were emitted since the last SYN_REPORT.
+	std::vector<struct input_event> m_events {};
+
 public:
 	UinputDevice() : m_fd {syscalls::open("/dev/uinput", O_WRONLY | O_NONBLOCK)} {};
 
-	~UinputDevice()
+	/*!
+	 * Takes ownership of a file descriptor that is already open.
+	 *
+	 * This is used for writing the events somewhere else than a uinput node, e.g. in tests.
+	 *
+	 * @param[in] fd The file descriptor that receives the events.
+	 */
+	explicit UinputDevice(const int fd) : m_fd {fd} {};
+
+	~UinputDevice() override
 	{
 		try {
 			syscalls::ioctl(m_fd, UI_DEV_DESTROY);
@@ -48,7 +65,7 @@ public:
 	 *
 	 * @param[in] name The new name.
 	 */
-	void set_name(std::string name)
+	void set_name(std::string name) override
 	{
 		m_name = std::move(name);
 	}
@@ -60,7 +77,7 @@ public:
 	 *
 	 * @param[in] vendor The vendor ID.
 	 */
-	void set_vendor(const u16 vendor)
+	void set_vendor(const u16 vendor) override
 	{
 		m_vendor = vendor;
 	}
@@ -72,7 +89,7 @@ public:
 	 *
 	 * @param[in] product The product ID.
 	 */
-	void set_product(const u16 product)
+	void set_product(const u16 product) override
 	{
 		m_product = product;
 	}
@@ -84,7 +101,7 @@ public:
 	 *
 	 * @param[in] version The firmware or hardware revision.
 	 */
-	void set_version(const u16 version)
+	void set_version(const u16 version) override
 	{
 		m_version = version;
 	}
@@ -96,7 +113,7 @@ public:
 	 *
 	 * @param[in] ev The event type to enable (e.g. EV_KEY or EV_ABS).
 	 */
-	void set_evbit(const i32 ev) const
+	void set_evbit(const i32 ev) const override
 	{
 		syscalls::ioctl(m_fd, UI_SET_EVBIT, ev);
 	}
@@ -108,7 +125,7 @@ public:
 	 *
 	 * @param[in] prop The property to enable (e.g. INPUT_PROP_POINTER).
 	 */
-	void set_propbit(const i32 prop) const
+	void set_propbit(const i32 prop) const override
 	{
 		syscalls::ioctl(m_fd, UI_SET_PROPBIT, prop);
 	}
@@ -120,11 +137,35 @@ public:
 	 *
 	 * @param[in] key They key to enable (e.g. BTN_TOUCH).
 	 */
-	void set_keybit(const i32 key) const
+	void set_keybit(const i32 key) const override
 	{
 		syscalls::ioctl(m_fd, UI_SET_KEYBIT, key);
 	}
 
+	/*!
+	 * Enables a relative axis for this device.
+	 *
+	 * Must be called before @ref create().
+	 *
+	 * @param[in] rel The axis to enable (e.g. REL_WHEEL).
+	 */
+	void set_relbit(const i32 rel) const override
+	{
+		syscalls::ioctl(m_fd, UI_SET_RELBIT, rel);
+	}
+
+	/*!
+	 * Enables a miscellaneous event for this device.
+	 *
+	 * Must be called before @ref create().
+	 *
+	 * @param[in] msc The event to enable (e.g. MSC_SERIAL).
+	 */
+	void set_mscbit(const i32 msc) const override
+	{
+		syscalls::ioctl(m_fd, UI_SET_MSCBIT, msc);
+	}
+
 	/*!
 	 * Enables an axis event for this device.
 	 *
@@ -135,7 +176,7 @@ public:
 	 * @param[in] max The maximal value of the axis.
 	 * @param[in] res The resolution of the axis, for converting virtual to physical units.
 	 */
-	void set_absinfo(const u16 code, const i32 min, const i32 max, const i32 res) const
+	void set_absinfo(const u16 code, const i32 min, const i32 max, const i32 res) const override
 	{
 		struct uinput_abs_setup abs {};
 
@@ -150,7 +191,7 @@ public:
 	/*!
 	 * Finalizes the device creation.
 	 */
-	void create() const
+	void create() const override
 	{
 		struct uinput_setup setup {};
 
@@ -169,13 +210,16 @@ public:
 	/*!
 	 * Emits an event.
 	 *
+	 * Events are collected until a SYN_REPORT is emitted, and then written to the device
+	 * all at once. This way, every frame only costs a single syscall.
+	 *
 	 * Must be called after @ref create().
 	 *
 	 * @param[in] type The event type.
 	 * @param[in] key The key of the button or axis.
 	 * @param[in] value The value of the button or axis.
 	 */
-	void emit(const u16 type, const u16 key, const i32 value) const
+	void emit(const u16 type, const u16 key, const i32 value) override
 	{
 		struct input_event ie {};
 
@@ -183,7 +227,22 @@ public:
 		ie.code = key;
 		ie.value = value;
 
-		syscalls::write(m_fd, ie);
+		m_events.push_back(ie);
+
+		if (type != EV_SYN || key != SYN_REPORT)
+			return;
+
+		const gsl::span<const struct input_event> events {m_events};
+
+		try {
+			syscalls::write(m_fd, events);
+		} catch (const std::exception & /* unused */) {
+			// Drop the events, otherwise they would be sent again with the next frame.
+			m_events.clear();
+			throw;
+		}
+
+		m_events.clear();
 	}
 };
 
@@ -63,15 +63,19 @@ public:
 
 		const u64 size = casts::to<u64>(data.size());
 
+		// The upper half of the header tells which source sent the data.
+		const u64 header = size | (casts::to<u64>(m_source) << 32);
+		const u64 padded = std::max(size, m_info.buffer_size);
+
 		// NOLINTNEXTLINE(cppcoreguidelines-pro-type-reinterpret-cast)
-		m_writer.write(reinterpret_cast<const char *>(&size), sizeof(size));
+		m_writer.write(reinterpret_cast<const char *>(&header), sizeof(header));
 
 		// NOLINTNEXTLINE(cppcoreguidelines-pro-type-reinterpret-cast)
 		m_writer.write(reinterpret_cast<char *>(data.data()),
 		               casts::to<std::streamsize>(size));
 
 		// Pad the data with zeros, so that we always write a full buffer.
-		std::fill_n(std::ostream_iterator<u8>(m_writer), m_info.buffer_size - size, '\0');
+		std::fill_n(std::ostream_iterator<u8>(m_writer), padded - size, '\0');
 	}
 };
 
@@ -38,6 +38,13 @@ public:
 	 */
 	T orientation = casts::to<T>(0);
 
+	/*
+	 * The highest value of the heatmap inside of the contact, above the neutral value.
+	 *
+	 * Range: [0, <maximum heatmap value>]
+	 */
+	T intensity = casts::to<T>(0);
+
 	/*
 	 * Whether the stored values are normalized.
 	 */
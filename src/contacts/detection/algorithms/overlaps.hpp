@@ -66,14 +66,43 @@ inline f64 overlap(const Box &a, const Box &b)
 	return iou;
 }
 
+/*!
+ * Checks whether two clusters were merged into one in the previous frame.
+ *
+ * @param[in] a The first cluster.
+ * @param[in] b The second cluster.
+ * @param[in] previous The clusters that were created by merging in the previous frame.
+ * @return Whether the centers of both clusters lie inside of the same merged cluster.
+ */
+inline bool was_merged(const Box &a, const Box &b, const std::vector<Box> &previous)
+{
+	for (const Box &box : previous) {
+		if (box.contains(a.center()) && box.contains(b.center()))
+			return true;
+	}
+
+	return false;
+}
+
 /*!
  * Searches for overlaps in a list of clusters.
  *
+ * Clusters that were merged in the previous frame stay merged until their overlap drops
+ * below the split threshold. Other clusters are only merged once their overlap reaches
+ * the merge threshold.
+ *
  * @param[in] clusters The list of clusters to check for overlaps.
+ * @param[in] previous The clusters that were created by merging in the previous frame.
+ * @param[in] merge_threshold The overlap above which separate clusters are merged.
+ * @param[in] split_threshold The overlap below which merged clusters are split again.
  * @param[out] overlaps A reference to the vector where overlapping pairs are stored.
  * @return Whether any overlaps have been found.
  */
-inline bool search(const std::vector<Box> &clusters, std::vector<Vector2<usize>> &overlaps)
+inline bool search(const std::vector<Box> &clusters,
+                   const std::vector<Box> &previous,
+                   const f64 merge_threshold,
+                   const f64 split_threshold,
+                   std::vector<Vector2<usize>> &overlaps)
 {
 	bool found_overlap = false;
 	const usize size = clusters.size();
@@ -87,8 +116,11 @@ inline bool search(const std::vector<Box> &clusters, std::vector<Vector2<usize>>
 		for (usize j = i + 1; j < size; j++) {
 			const Box &b = clusters[j];
 
-			// Ignore clusters that overlap by less than 50%
-			if (overlap(a, b) < 0.5)
+			const bool merged = was_merged(a, b, previous);
+			const f64 threshold = merged ? split_threshold : merge_threshold;
+
+			// Ignore clusters that don't overlap enough
+			if (overlap(a, b) < threshold)
 				continue;
 
 			found_overlap = true;
@@ -109,29 +141,49 @@ inline bool search(const std::vector<Box> &clusters, std::vector<Vector2<usize>>
  * Merges overlapping clusters.
  *
  * The function will iterate over the list of clusters multiple times,
- * and search for ones that overlap by more than 50%. If overlaps were found,
+ * and search for ones that overlap by more than the threshold. If overlaps were found,
  * one of the overlapping clusters will be extended, and the other one will be
  * dropped. If no overlaps were found in one iteration, the function returns.
  *
+ * To keep contacts that are close to each other from flickering between one and two clusters,
+ * clusters that were merged in the previous frame are compared against the split threshold
+ * instead of the merge threshold.
+ *
  * @param[in,out] clusters The list of clusters to check for overlaps.
  * @param[in] temp A temporary buffer for storing the result of an iteration.
+ * @param[in] previous The clusters that were created by merging in the previous frame.
+ * @param[out] merged The clusters that were created by merging in this frame.
+ * @param[in] merge_threshold The overlap above which separate clusters are merged.
+ * @param[in] split_threshold The overlap below which merged clusters are split again.
  * @param[in] iterations How many times the function will try to merge overlaps before aborting.
  */
-inline void merge(std::vector<Box> &clusters, std::vector<Box> &temp, const usize iterations)
+inline void merge(std::vector<Box> &clusters,
+                  std::vector<Box> &temp,
+                  const std::vector<Box> &previous,
+                  std::vector<Box> &merged,
+                  const f64 merge_threshold,
+                  const f64 split_threshold,
+                  const usize iterations)
 {
 	std::vector<Vector2<usize>> overlaps {clusters.size()};
 
+	// Which of the clusters were created by merging other clusters.
+	std::vector<bool> flags(clusters.size(), false);
+	std::vector<bool> flags_temp {};
+
 	temp.clear();
+	merged.clear();
 
 	// Repeat the merging process until no new overlaps were detected
 	for (usize j = 0; j < iterations; j++) {
 		const usize size = clusters.size();
 
-		if (!impl::search(clusters, overlaps))
+		if (!impl::search(clusters, previous, merge_threshold, split_threshold, overlaps))
 			break;
 
 		for (usize i = 0; i < size; i++) {
 			Box &cluster = clusters[i];
+			bool flag = flags[i];
 			bool drop_cluster = false;
 
 			for (const Vector2<usize> &pair : overlaps) {
@@ -149,16 +201,26 @@ inline void merge(std::vector<Box> &clusters, std::vector<Box> &temp, const usiz
 					continue;
 
 				cluster = cluster.merged(clusters[b]);
+				flag = true;
 			}
 
 			if (drop_cluster)
 				continue;
 
 			temp.push_back(std::move(cluster));
+			flags_temp.push_back(flag);
 		}
 
 		std::swap(clusters, temp);
+		std::swap(flags, flags_temp);
+
 		temp.clear();
+		flags_temp.clear();
+	}
+
+	for (usize i = 0; i < clusters.size(); i++) {
+		if (flags[i])
+			merged.push_back(clusters[i]);
 	}
 
 	if (iterations == 0)
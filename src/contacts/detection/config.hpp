@@ -49,6 +49,18 @@ public:
 	 * the recursive cluster search will stop once it reaches it.
 	 */
 	T deactivation_threshold = casts::to<T>(20);
+
+	/*
+	 * If two separate clusters overlap by more than this value (range 0-1),
+	 * they are merged into one cluster.
+	 */
+	f64 merge_threshold = 0.5;
+
+	/*
+	 * If two clusters that were merged in the previous frame overlap by less than this
+	 * value (range 0-1), they are split into two clusters again.
+	 */
+	f64 split_threshold = 0.5;
 };
 
 } // namespace iptsd::contacts::detection
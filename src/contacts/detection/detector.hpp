@@ -61,6 +61,10 @@ private:
 	// Temporary storage for cluster spanning.
 	std::vector<Box> m_clusters_temp {};
 
+	// The clusters that were created by merging, in this and in the previous frame.
+	std::vector<Box> m_merged {};
+	std::vector<Box> m_merged_last {};
+
 	// Input parameters for gaussian fitting.
 	std::vector<gaussian::Parameters<TFit>> m_fitting_params {};
 
@@ -186,7 +190,15 @@ public:
 		}
 
 		// Merge overlapping clusters
-		overlaps::merge(m_clusters, m_clusters_temp, 5);
+		std::swap(m_merged, m_merged_last);
+
+		overlaps::merge(m_clusters,
+		                m_clusters_temp,
+		                m_merged_last,
+		                m_merged,
+		                m_config.merge_threshold,
+		                m_config.split_threshold,
+		                5);
 
 		// Prepare clusters for gaussian fitting
 		for (const Box &cluster : m_clusters) {
@@ -225,6 +237,16 @@ public:
 			Vector2<TFit> size = ellipse::size(solver.eigenvalues());
 			TFit orientation = ellipse::angle<TFit>(solver.eigenvectors());
 
+			// min() and max() are inclusive so we need to add one
+			const Vector2<Eigen::Index> extent = p.bounds.sizes() + one;
+
+			const auto block = m_img_neutral.block(p.bounds.min().y(),
+			                                       p.bounds.min().x(),
+			                                       extent.y(),
+			                                       extent.x());
+
+			const T intensity = block.maxCoeff();
+
 			// Normalize dimensions.
 			if (m_config.normalize) {
 				mean = mean.cwiseQuotient(dimensions.cast<TFit>());
@@ -235,6 +257,7 @@ public:
 			contacts.push_back(Contact<T> {mean.template cast<T>(),
 			                               size.template cast<T>(),
 			                               gsl::narrow_cast<T>(orientation),
+			                               intensity,
 			                               m_config.normalize});
 		}
 	}
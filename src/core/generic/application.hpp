@@ -7,6 +7,7 @@
 #include "device.hpp"
 #include "dft.hpp"
 #include "errors.hpp"
+#include "statistics.hpp"
 
 #include <common/casts.hpp>
 #include <common/error.hpp>
@@ -14,10 +15,18 @@
 #include <contacts/finder.hpp>
 #include <ipts/data.hpp>
 #include <ipts/parser.hpp>
+#include <ipts/protocol/report.hpp>
 
+#include <fmt/format.h>
 #include <spdlog/spdlog.h>
 
+#include <algorithm>
+#include <cmath>
+#include <exception>
 #include <functional>
+#include <set>
+#include <string>
+#include <utility>
 #include <vector>
 
 namespace iptsd::core {
@@ -36,6 +45,12 @@ namespace iptsd::core {
  * need to be run by an application runner.
  */
 class Application {
+private:
+	/*
+	 * How many buffers are sampled to detect which data formats the device is using.
+	 */
+	constexpr static usize DETECTION_FRAMES = 100;
+
 protected:
 	/*
 	 * The configuration for this application.
@@ -88,6 +103,34 @@ protected:
 	 */
 	DftStylus m_dft;
 
+	/*
+	 * The types of unknown frames that were already reported.
+	 * Only the first occurrence of each type is logged as a warning.
+	 */
+	std::set<u32> m_unknown {};
+
+	/*
+	 * Counters for the processed data, which are logged periodically if enabled.
+	 */
+	Statistics m_stats {};
+
+	/*
+	 * The data formats that were encountered, and how many buffers were sampled for them.
+	 */
+	std::set<std::string> m_formats {};
+	usize m_sampled = 0;
+
+	/*
+	 * The state bits of the last stylus report, for logging when they change.
+	 */
+	std::string m_stylus_state {};
+
+	/*
+	 * The device that the buffer which is being processed was read from.
+	 * 0 is the main device, the additional sources are counted from 1.
+	 */
+	usize m_source = 0;
+
 public:
 	Application(const Config &config,
 	            const DeviceInfo &info,
@@ -98,24 +141,109 @@ public:
 		  m_finder {config.contacts()},
 		  m_dft {config, metadata}
 	{
-		if (m_config.width == 0 || m_config.height == 0)
-			throw common::Error<Error::InvalidScreenSize> {};
+		Application::validate(m_config);
+		this->configure_parser();
 
 		m_parser.on_heatmap = [&](const auto &data) { this->process_heatmap(data); };
 		m_parser.on_stylus = [&](const auto &data) { this->process_stylus(data); };
 		m_parser.on_dft = [&](const auto &data) { this->process_dft(data); };
+
+		m_parser.on_report = [&](const u32 type, const usize size) {
+			m_stats.reports[type]++;
+			spdlog::debug("ipts: Report of type {:#04x} ({} bytes)", type, size);
+
+			this->detect_format(type);
+		};
+
+		m_parser.on_unknown = [&](const u32 type, const usize size) {
+			this->process_unknown(type, size);
+		};
 	}
 
 	virtual ~Application() = default;
 
+	/*!
+	 * Replaces the configuration of the running application.
+	 *
+	 * Options that define the layout of the created input devices (like the rotation or the
+	 * names of the devices) are kept, they only change when the application is restarted.
+	 * The exception is a change of the display orientation, which switches to the rotation
+	 * and screen mapping of the new orientation. If the new configuration is invalid,
+	 * the old one stays in place.
+	 *
+	 * This must not be called while data is being processed.
+	 *
+	 * @param[in] config The new configuration.
+	 */
+	void reload(const Config &config)
+	{
+		const Config updated = Application::keep_fixed(m_config, config);
+		Application::validate(updated);
+
+		const Config previous = m_config;
+		m_config = updated;
+
+		// Starting over also drops the contacts that are currently tracked.
+		m_finder = contacts::Finder<f64> {m_config.contacts()};
+		m_dft.reload(m_config);
+
+		this->configure_parser();
+		this->on_reload(previous);
+	}
+
+	/*!
+	 * Switches touch input on or off, without changing the loaded configuration.
+	 *
+	 * The state stays until the configuration is reloaded.
+	 * This must not be called while data is being processed.
+	 */
+	void toggle_touch()
+	{
+		const Config previous = m_config;
+		m_config.touch_disable = !m_config.touch_disable;
+
+		spdlog::info("Touchscreen {}", m_config.touch_disable ? "disabled" : "enabled");
+		this->on_reload(previous);
+	}
+
+	/*!
+	 * Switches stylus input on or off, without changing the loaded configuration.
+	 *
+	 * The state stays until the configuration is reloaded.
+	 * This must not be called while data is being processed.
+	 */
+	void toggle_stylus()
+	{
+		const Config previous = m_config;
+		m_config.stylus_disable = !m_config.stylus_disable;
+
+		spdlog::info("Stylus {}", m_config.stylus_disable ? "disabled" : "enabled");
+		this->on_reload(previous);
+	}
+
 	/*!
 	 * Parse and process an IPTS data buffer.
 	 *
 	 * @param[in] data The buffer to process.
+	 * @param[in] source The device that sent the buffer, 0 for the main device.
 	 */
-	void process(const gsl::span<u8> data)
+	void process(const gsl::span<u8> data, const usize source = 0)
 	{
-		this->on_data(data);
+		this->log_statistics();
+
+		m_source = source;
+
+		m_stats.frames++;
+
+		try {
+			this->on_data(data);
+		} catch (const std::exception & /* unused */) {
+			m_stats.dropped++;
+			throw;
+		}
+
+		if (m_sampled < DETECTION_FRAMES && ++m_sampled == DETECTION_FRAMES)
+			this->log_formats();
 	}
 
 	/*!
@@ -128,6 +256,23 @@ public:
 	 */
 	virtual void on_stop() {};
 
+	/*!
+	 * For running application specific code when the device didn't send data for a while.
+	 */
+	virtual void on_idle() {};
+
+	/*!
+	 * For running application specific code before the device is opened again.
+	 */
+	virtual void on_reopen() {};
+
+	/*!
+	 * For running application specific code after the configuration was reloaded.
+	 *
+	 * @param[in] previous The configuration that was used before.
+	 */
+	virtual void on_reload(const Config & /* unused */) {};
+
 protected:
 	/*!
 	 * For replacing the parsing step of the data with application
@@ -148,7 +293,237 @@ protected:
 	 */
 	virtual void on_stylus(const ipts::StylusData & /* unused */) {};
 
+	/*!
+	 * Moves contacts from the coordinate space of the digitizer into the one of the screen.
+	 *
+	 * This applies Config/Transform, followed by InvertX, InvertY, SwapXY and Rotation.
+	 *
+	 * @param[in,out] contacts The contacts that were found in the heatmap.
+	 */
+	void place_contacts(std::vector<contacts::Contact<f64>> &contacts) const
+	{
+		for (contacts::Contact<f64> &contact : contacts) {
+			contact.mean = m_config.transform_position(contact.mean);
+			contact.mean = this->invert_position(contact.mean);
+
+			if (m_config.invert_x != m_config.invert_y)
+				contact.orientation = 1.0 - contact.orientation;
+
+			// Transposing mirrors the contact along the diagonal.
+			if (m_config.swap_xy) {
+				contact.mean = Vector2<f64> {contact.mean.y(), contact.mean.x()};
+				contact.orientation = std::fmod(1.5 - contact.orientation, 1.0);
+			}
+
+			contact.mean = this->rotate_position(contact.mean);
+
+			if (m_config.rotates_axes())
+				contact.orientation = std::fmod(contact.orientation + 0.5, 1.0);
+		}
+	}
+
 private:
+	/*!
+	 * Checks if a configuration can be used by the application.
+	 *
+	 * @param[in] config The configuration to check.
+	 */
+	static void validate(const Config &config)
+	{
+		if (config.width == 0 || config.height == 0)
+			throw common::Error<Error::InvalidScreenSize> {};
+
+		if (config.stylus_pressure_min >= config.stylus_pressure_max)
+			throw common::Error<Error::InvalidPressureRange> {};
+
+		if (config.stylus_output_pressure_min >= config.stylus_output_pressure_max)
+			throw common::Error<Error::InvalidPressureRange> {};
+
+		if (config.stylus_pressure_offset < 0 || config.stylus_pressure_offset >= 1)
+			throw common::Error<Error::InvalidPressureOffset> {};
+
+		if (config.rotation % 90 != 0 || config.rotation >= 360)
+			throw common::Error<Error::InvalidRotation> {};
+
+		if (config.coord_scale <= 0)
+			throw common::Error<Error::InvalidCoordScale> {};
+
+		if (config.stylus_smoothing < 0 || config.stylus_smoothing >= 1)
+			throw common::Error<Error::InvalidSmoothing> {};
+
+		if (config.stylus_tilt_smoothing < 0 || config.stylus_tilt_smoothing >= 1)
+			throw common::Error<Error::InvalidSmoothing> {};
+
+		if (config.touch_smoothing < 0 || config.touch_smoothing >= 1)
+			throw common::Error<Error::InvalidSmoothing> {};
+
+		if (config.stylus_snap_grid < 0)
+			throw common::Error<Error::InvalidSnapGrid> {};
+
+		if (config.stylus_prediction > 0 && config.stylus_timestamp_unit <= 0)
+			throw common::Error<Error::InvalidPrediction> {};
+
+		if ((config.screen_width == 0) != (config.screen_height == 0))
+			throw common::Error<Error::InvalidScreenResolution> {};
+
+		if (config.stylus_max_x == 0 || config.stylus_max_y == 0)
+			throw common::Error<Error::InvalidStylusRange> {};
+
+		if (config.stylus_tilt_max == 0)
+			throw common::Error<Error::InvalidTiltRange> {};
+
+		if (config.stylus_altitude_min < 0 || config.stylus_altitude_max > 90)
+			throw common::Error<Error::InvalidAltitudeRange> {};
+
+		if (config.stylus_altitude_min > config.stylus_altitude_max)
+			throw common::Error<Error::InvalidAltitudeRange> {};
+
+		if (config.stylus_mpp_1_0_max_pressure == 0)
+			throw common::Error<Error::InvalidMaxPressure> {};
+
+		if (config.orientation % 90 != 0 || config.orientation >= 360)
+			throw common::Error<Error::InvalidOrientation> {};
+
+		const std::string &hand = config.touch_handedness;
+
+		if (hand != "none" && hand != "right" && hand != "left")
+			throw common::Error<Error::InvalidHandedness> {};
+
+		const std::string &format = config.stylus_report_format;
+
+		if (format != "auto" && format != "mpp-1.0" && format != "mpp-1.51")
+			throw common::Error<Error::InvalidReportFormat> {};
+
+		const f64 merge = config.contacts_merge_threshold;
+		const f64 split = config.contacts_split_threshold;
+
+		if (split < 0 || split > merge || merge > 1)
+			throw common::Error<Error::InvalidMergeThreshold> {};
+
+		if (config.touch_scroll_distance <= 0)
+			throw common::Error<Error::InvalidScrollDistance> {};
+
+		if (config.stylus_contact_release_pressure > config.stylus_contact_min_pressure)
+			throw common::Error<Error::InvalidReleasePressure> {};
+
+		const f64 left = config.touch_touchpad_left;
+		const f64 right = config.touch_touchpad_right;
+		const f64 top = config.touch_touchpad_top;
+		const f64 bottom = config.touch_touchpad_bottom;
+
+		if (left < 0 || left >= right || right > 1)
+			throw common::Error<Error::InvalidTouchpadRegion> {};
+
+		if (top < 0 || top >= bottom || bottom > 1)
+			throw common::Error<Error::InvalidTouchpadRegion> {};
+
+		const f64 output_left = config.stylus_output_left;
+		const f64 output_right = config.stylus_output_right;
+		const f64 output_top = config.stylus_output_top;
+		const f64 output_bottom = config.stylus_output_bottom;
+
+		if (output_left < 0 || output_left >= output_right || output_right > 1)
+			throw common::Error<Error::InvalidOutputRegion> {};
+
+		if (output_top < 0 || output_top >= output_bottom || output_bottom > 1)
+			throw common::Error<Error::InvalidOutputRegion> {};
+
+		if (config.touch_touchpad_speed <= 0)
+			throw common::Error<Error::InvalidTouchpadSpeed> {};
+
+		if (config.touch_pressure_intensity <= 0 || config.touch_pressure_max == 0)
+			throw common::Error<Error::InvalidTouchPressure> {};
+	}
+
+	/*!
+	 * Takes the options that can't be changed at runtime from the current configuration.
+	 *
+	 * @param[in] current The configuration the application is running with.
+	 * @param[in] loaded The newly loaded configuration.
+	 * @return The loaded configuration, with the fixed options of the current one.
+	 */
+	[[nodiscard]] static Config keep_fixed(const Config &current, const Config &loaded)
+	{
+		Config config = loaded;
+
+		// The size of the screen defines the axes of the input devices.
+		config.width = current.width;
+		config.height = current.height;
+		config.swap_xy = current.swap_xy;
+
+		// The devices are only opened when connecting.
+		config.sources = current.sources;
+
+		// The mapping of the screen can only change together with the orientation.
+		if (loaded.orientation == current.orientation) {
+			config.rotation = current.rotation;
+			config.screen_width = current.screen_width;
+			config.screen_height = current.screen_height;
+		}
+
+		// The keys and ranges that are registered with the input devices.
+		config.stylus_keys = current.stylus_keys;
+		config.stylus_button_double_key = current.stylus_button_double_key;
+		config.stylus_button_hold_key = current.stylus_button_hold_key;
+		config.stylus_wake_key = current.stylus_wake_key;
+		config.stylus_rubber_tool = current.stylus_rubber_tool;
+		config.stylus_rubber_keys = current.stylus_rubber_keys;
+		config.stylus_raw_timestamp = current.stylus_raw_timestamp;
+		config.stylus_tilt_max = current.stylus_tilt_max;
+		config.stylus_output_pressure_min = current.stylus_output_pressure_min;
+		config.stylus_output_pressure_max = current.stylus_output_pressure_max;
+		config.stylus_output_left = current.stylus_output_left;
+		config.stylus_output_top = current.stylus_output_top;
+		config.stylus_output_right = current.stylus_output_right;
+		config.stylus_output_bottom = current.stylus_output_bottom;
+		config.stylus_disable_tilt = current.stylus_disable_tilt;
+		config.stylus_multitouch = current.stylus_multitouch;
+		config.stylus_emit_serial = current.stylus_emit_serial;
+		config.stylus_emit_timestamp = current.stylus_emit_timestamp;
+		config.stylus_record = current.stylus_record;
+		config.stylus_record_limit = current.stylus_record_limit;
+		config.touch_singletouch = current.touch_singletouch;
+		config.touch_scroll = current.touch_scroll;
+		config.touch_touchpad = current.touch_touchpad;
+		config.touch_mouse = current.touch_mouse;
+		config.touch_pressure = current.touch_pressure;
+		config.touch_report_palms = current.touch_report_palms;
+		config.touch_pressure_max = current.touch_pressure_max;
+
+		config.uinput_touch_name = current.uinput_touch_name;
+		config.uinput_stylus_name = current.uinput_stylus_name;
+		config.uinput_singletouch_name = current.uinput_singletouch_name;
+		config.uinput_keyboard_name = current.uinput_keyboard_name;
+		config.uinput_scroll_name = current.uinput_scroll_name;
+		config.uinput_touchpad_name = current.uinput_touchpad_name;
+		config.uinput_vendor = current.uinput_vendor;
+		config.uinput_product = current.uinput_product;
+		config.uinput_version = current.uinput_version;
+
+		return config;
+	}
+
+	/*!
+	 * Passes the options for parsing the incoming data to the parser.
+	 */
+	void configure_parser()
+	{
+		m_parser.stylus_max_x = m_config.stylus_max_x;
+		m_parser.stylus_max_y = m_config.stylus_max_y;
+		m_parser.stylus_max_pressure_mpp_1_0 = m_config.stylus_mpp_1_0_max_pressure;
+		m_parser.stylus_format.reset();
+
+		if (m_config.stylus_report_format == "mpp-1.0")
+			m_parser.stylus_format = ipts::protocol::report::Type::StylusMPP_1_0;
+		else if (m_config.stylus_report_format == "mpp-1.51")
+			m_parser.stylus_format = ipts::protocol::report::Type::StylusMPP_1_51;
+
+		if (m_parser.stylus_format.has_value()) {
+			spdlog::warn("Overriding the format of all stylus reports with {}!",
+			             m_config.stylus_report_format);
+		}
+	}
+
 	/*!
 	 * Runs contact detection on an IPTS heatmap.
 	 *
@@ -165,6 +540,8 @@ private:
 		if (rows == 0 || cols == 0)
 			return;
 
+		m_stats.heatmaps++;
+
 		// Make sure the heatmap buffer has the right size
 		if (m_heatmap.rows() != rows || m_heatmap.cols() != cols)
 			m_heatmap.conservativeResize(rows, cols);
@@ -184,17 +561,8 @@ private:
 		// Search for contacts
 		m_finder.find(m_heatmap, m_contacts);
 
-		// Invert contact coordinates if neccessary
-		for (contacts::Contact<f64> &contact : m_contacts) {
-			if (m_config.invert_x)
-				contact.mean.x() = 1.0 - contact.mean.x();
-
-			if (m_config.invert_y)
-				contact.mean.y() = 1.0 - contact.mean.y();
-
-			if (m_config.invert_x != m_config.invert_y)
-				contact.orientation = 1.0 - contact.orientation;
-		}
+		// Move the contacts into the coordinate space of the screen.
+		this->place_contacts(m_contacts);
 
 		// Hand off the found contacts to the handler code.
 		this->on_contacts(m_contacts);
@@ -207,17 +575,108 @@ private:
 	 */
 	void process_stylus(const ipts::StylusData &data)
 	{
+		m_stats.stylus++;
+
+		this->log_stylus_state(data);
+
 		ipts::StylusData corrected = data;
 
+		// Some pens report their eraser as a separate stylus, instead of setting the bit.
+		if (m_config.is_rubber(data.serial))
+			corrected.rubber = true;
+
+		// Some pens report the highest pressure while hovering, and the lowest when pressed
+		if (m_config.stylus_invert_pressure)
+			corrected.pressure = std::max(1.0 - corrected.pressure, 0.0);
+
 		// Correct position based on tip-transmitter distance
 		const Vector2<f64> off = this->calculate_offset(data.altitude, data.azimuth);
 		corrected.x += off.x();
 		corrected.y += off.y();
 
+		/*
+		 * Correct a skewed or misaligned digitizer. The stylus was already mirrored by
+		 * InvertX and InvertY, so it is moved back into the coordinate space of the
+		 * digitizer first, to apply the transform in the same way as for touch.
+		 */
+		const Vector2<f64> raw {corrected.x, corrected.y};
+		const Vector2<f64> digitizer = this->invert_position(raw);
+		const Vector2<f64> transformed =
+			this->invert_position(m_config.transform_position(digitizer));
+
+		corrected.x = transformed.x();
+		corrected.y = transformed.y();
+
+		// Mirror the stylus, keeping the tilt physically correct
+		if (m_config.stylus_invert_x) {
+			corrected.x = 1.0 - corrected.x;
+			corrected.azimuth = M_PI - corrected.azimuth;
+		}
+
+		if (m_config.stylus_invert_y) {
+			corrected.y = 1.0 - corrected.y;
+			corrected.azimuth = -corrected.azimuth;
+		}
+
+		// Transposing mirrors the stylus along the diagonal, which exchanges the tilt axes
+		if (m_config.swap_xy) {
+			std::swap(corrected.x, corrected.y);
+			corrected.azimuth = -M_PI_2 - corrected.azimuth;
+		}
+
+		// Rotate the stylus into the coordinate space of the screen
+		const Vector2<f64> pos {corrected.x, corrected.y};
+		const Vector2<f64> rotated = this->rotate_position(pos);
+
+		// Apply the calibration of this stylus
+		const StylusCalibration &cal = m_config.calibration(data.serial);
+
+		corrected.x = rotated.x() * cal.scale_x + cal.offset_x / m_config.output_width();
+		corrected.y = rotated.y() * cal.scale_y + cal.offset_y / m_config.output_height();
+
+		const f64 angle = casts::to<f64>(m_config.rotation) / 180.0 * M_PI;
+		corrected.azimuth = std::fmod(corrected.azimuth - angle, 2 * M_PI);
+
+		if (corrected.azimuth < 0)
+			corrected.azimuth += 2 * M_PI;
+
 		// Hand off the stylus data to the handler code.
 		this->on_stylus(corrected);
 	}
 
+	/*!
+	 * Logs the state bits of the stylus whenever they change.
+	 *
+	 * @param[in] data The current state of the stylus.
+	 */
+	void log_stylus_state(const ipts::StylusData &data)
+	{
+		if (!spdlog::should_log(spdlog::level::debug))
+			return;
+
+		const std::pair<bool, const char *> bits[] = {
+			{data.proximity, "proximity"},
+			{data.contact, "contact"},
+			{data.button, "button"},
+			{data.rubber, "rubber"},
+		};
+
+		std::string state {};
+		for (const auto &[set, name] : bits) {
+			if (set)
+				state += state.empty() ? name : std::string {", "} + name;
+		}
+
+		if (state.empty())
+			state = "none";
+
+		if (state == m_stylus_state)
+			return;
+
+		spdlog::debug("Stylus {:08X}: {}", data.serial, state);
+		m_stylus_state = std::move(state);
+	}
+
 	/*!
 	 * Handles incoming DFT windows.
 	 *
@@ -228,10 +687,179 @@ private:
 	 */
 	void process_dft(const ipts::DftWindow &data)
 	{
+		m_stats.dft++;
+
 		m_dft.input(data);
 		this->process_stylus(m_dft.get_stylus());
 	}
 
+	/*!
+	 * Handles frames that were skipped by the parser, because their type is unknown.
+	 *
+	 * The first frame of every type is reported as a warning, to make it obvious when
+	 * a device is sending data that iptsd doesn't understand yet.
+	 *
+	 * @param[in] type The type of the frame.
+	 * @param[in] size The size of the frame.
+	 */
+	void process_unknown(const u32 type, const usize size)
+	{
+		m_stats.unknown[type]++;
+
+		const bool first = m_unknown.insert(type).second;
+		const auto level = first ? spdlog::level::warn : spdlog::level::debug;
+
+		spdlog::log(level, "ipts: Skipping unknown frame {:#04x} ({} bytes)", type, size);
+	}
+
+	/*!
+	 * Records which data format a report belongs to.
+	 *
+	 * Formats that only show up after the detection phase (e.g. because the stylus was not
+	 * in proximity yet) are logged as soon as they are encountered.
+	 *
+	 * @param[in] type The type of the report.
+	 */
+	void detect_format(const u32 type)
+	{
+		const std::string format = Application::format_name(type);
+
+		if (format.empty())
+			return;
+
+		const bool first = m_formats.insert(format).second;
+
+		if (first && m_sampled >= DETECTION_FRAMES)
+			spdlog::info("Detected new data format: {}", format);
+	}
+
+	/*!
+	 * Logs the data formats that were detected in the first buffers.
+	 */
+	void log_formats() const
+	{
+		if (m_formats.empty()) {
+			spdlog::warn("No supported data was found in the first {} buffers. Your "
+			             "device might be using a format that iptsd doesn't support.",
+			             DETECTION_FRAMES);
+			return;
+		}
+
+		std::string formats {};
+		for (const std::string &format : m_formats)
+			formats += formats.empty() ? format : ", " + format;
+
+		spdlog::info("Detected data formats: {}", formats);
+	}
+
+	/*!
+	 * The name of the data format that a report belongs to.
+	 *
+	 * @param[in] type The type of the report.
+	 * @return The name of the format, or nothing if the report contains no input data.
+	 */
+	[[nodiscard]] static std::string format_name(const u32 type)
+	{
+		using Type = ipts::protocol::report::Type;
+
+		switch (gsl::narrow_cast<Type>(type)) {
+		case Type::HeatmapData:
+			return "heatmaps";
+		case Type::StylusMPP_1_0:
+			return "stylus (MPP 1.0)";
+		case Type::StylusMPP_1_51:
+			return "stylus (MPP 1.51)";
+		case Type::DftWindow:
+			return "DFT stylus";
+		default:
+			return "";
+		}
+	}
+
+	/*!
+	 * Logs the collected statistics and resets them, once the configured interval passed.
+	 */
+	void log_statistics()
+	{
+		if (m_config.statistics_interval == 0)
+			return;
+
+		const f64 elapsed = m_stats.elapsed();
+
+		if (elapsed < casts::to<f64>(m_config.statistics_interval))
+			return;
+
+		const f64 frames = casts::to<f64>(m_stats.frames) / elapsed;
+		const f64 stylus = casts::to<f64>(m_stats.stylus) / elapsed;
+		const f64 heatmaps = casts::to<f64>(m_stats.heatmaps) / elapsed;
+		const f64 dft = casts::to<f64>(m_stats.dft) / elapsed;
+
+		// How many of the received buffers could not be parsed.
+		f64 errors = 0;
+
+		if (m_stats.frames > 0)
+			errors = casts::to<f64>(m_stats.dropped) / casts::to<f64>(m_stats.frames);
+
+		spdlog::info("Statistics: {:.1f} frames/s ({} dropped, {:.1f}%), {:.1f} stylus/s, "
+		             "{:.1f} heatmaps/s, {:.1f} DFT windows/s",
+		             frames,
+		             m_stats.dropped,
+		             errors * 100,
+		             stylus,
+		             heatmaps,
+		             dft);
+
+		std::string reports {};
+		for (const auto &[type, count] : m_stats.reports)
+			reports += fmt::format(" {:#04x}={}", type, count);
+
+		std::string unknown {};
+		for (const auto &[type, count] : m_stats.unknown)
+			unknown += fmt::format(" {:#04x}={}", type, count);
+
+		spdlog::info("Statistics: Reports:{}", reports.empty() ? " none" : reports);
+
+		if (!unknown.empty())
+			spdlog::info("Statistics: Unknown frames:{}", unknown);
+
+		m_stats.reset();
+	}
+
+	/*!
+	 * Mirrors a normalized position, as configured by the Config/InvertX and Config/InvertY
+	 * options. Mirroring twice restores the original position.
+	 *
+	 * @param[in] position The position to mirror, in the range [0, 1].
+	 * @return The mirrored position.
+	 */
+	[[nodiscard]] Vector2<f64> invert_position(const Vector2<f64> &position) const
+	{
+		const f64 x = m_config.invert_x ? 1.0 - position.x() : position.x();
+		const f64 y = m_config.invert_y ? 1.0 - position.y() : position.y();
+
+		return Vector2<f64> {x, y};
+	}
+
+	/*!
+	 * Rotates a normalized position clockwise by the configured rotation.
+	 *
+	 * @param[in] position The position to rotate, in the range [0, 1].
+	 * @return The rotated position.
+	 */
+	[[nodiscard]] Vector2<f64> rotate_position(const Vector2<f64> &position) const
+	{
+		switch (m_config.rotation) {
+		case 90:
+			return Vector2<f64> {1.0 - position.y(), position.x()};
+		case 180:
+			return Vector2<f64> {1.0 - position.x(), 1.0 - position.y()};
+		case 270:
+			return Vector2<f64> {position.y(), 1.0 - position.x()};
+		default:
+			return position;
+		}
+	}
+
 	/*!
 	 * Calculates the tilt-based offset of the stylus position.
 	 *
@@ -10,31 +10,112 @@
 #include <contacts/config.hpp>
 #include <ipts/parser.hpp>
 
+#include <linux/input-event-codes.h>
+
+#include <algorithm>
+#include <map>
 #include <optional>
 #include <string>
+#include <vector>
 
 namespace iptsd::core {
 
+/*
+ * The calibration of a stylus, in the coordinate space of the screen.
+ */
+struct StylusCalibration {
+	// How many centimeters the position is moved on the X and Y axis.
+	f64 offset_x = 0;
+	f64 offset_y = 0;
+
+	// The factor by which the position is scaled on the X and Y axis.
+	f64 scale_x = 1;
+	f64 scale_y = 1;
+};
+
+/*
+ * The bits of the stylus state that can be mapped to a key.
+ */
+enum class StylusBit : u8 {
+	Button,
+	Rubber,
+};
+
+/*
+ * The options that change with the orientation of the display.
+ */
+struct OrientationProfile {
+	// The rotation of the touchscreen and stylus coordinates.
+	u16 rotation = 0;
+
+	// The resolution of the screen in pixels.
+	u32 screen_width = 0;
+	u32 screen_height = 0;
+
+	// The calibration of all styli that don't have their own.
+	StylusCalibration calibration {};
+};
+
 class Config {
 public:
 	// [Config]
 	bool invert_x = false;
 	bool invert_y = false;
+	bool swap_xy = false;
 
 	f64 width = 0;
 	f64 height = 0;
 
+	u16 rotation = 0;
+
+	u32 screen_width = 0;
+	u32 screen_height = 0;
+
+	usize statistics_interval = 0;
+	usize parse_error_limit = 0;
+	usize watchdog = 0;
+
+	u16 orientation = 0;
+	std::map<u16, OrientationProfile> orientations {};
+
+	std::vector<std::string> sources {};
+
+	std::vector<f64> transform {};
+	f64 coord_scale = 1;
+
 	// [Touch]
 	bool touch_disable = false;
 	bool touch_disable_on_palm = false;
+	usize touch_palm_max_contacts = 0;
+	f64 touch_palm_max_area = 0;
+	bool touch_report_palms = false;
 	bool touch_disable_on_stylus = false;
+	f64 touch_disable_near_stylus = 0;
+	std::string touch_handedness = "none";
 	f64 touch_overshoot = 0.5;
+	f64 touch_smoothing = 0;
+	usize touch_min_lifetime = 0;
+	bool touch_singletouch = false;
+	bool touch_scroll = false;
+	f64 touch_scroll_distance = 0.5;
+	bool touch_pressure = false;
+	f64 touch_pressure_intensity = 100;
+	u16 touch_pressure_max = 255;
+	bool touch_touchpad = false;
+	f64 touch_touchpad_left = 0;
+	f64 touch_touchpad_top = 0.6;
+	f64 touch_touchpad_right = 0.4;
+	f64 touch_touchpad_bottom = 1;
+	f64 touch_touchpad_speed = 40;
+	bool touch_mouse = false;
 
 	// [Contacts]
 	std::string contacts_neutral = "mode";
 	f64 contacts_neutral_value = 0;
 	f64 contacts_activation_threshold = 24;
 	f64 contacts_deactivation_threshold = 20;
+	f64 contacts_merge_threshold = 0.5;
+	f64 contacts_split_threshold = 0.5;
 	f64 contacts_size_thresh_min = 0.1;
 	f64 contacts_size_thresh_max = 0.5;
 	f64 contacts_position_thresh_min = 0.04;
@@ -48,7 +129,70 @@ public:
 
 	// [Stylus]
 	bool stylus_disable = false;
+	bool stylus_invert_x = false;
+	bool stylus_invert_y = false;
+	bool stylus_invert_pressure = false;
+	bool stylus_disable_hover = false;
 	f64 stylus_tip_distance = 0;
+	f64 stylus_pressure_min = 0;
+	f64 stylus_pressure_max = 1;
+	f64 stylus_pressure_gamma = 1;
+	f64 stylus_pressure_offset = 0;
+	u16 stylus_output_pressure_min = 0;
+	u16 stylus_output_pressure_max = 4096;
+	f64 stylus_output_left = 0;
+	f64 stylus_output_top = 0;
+	f64 stylus_output_right = 1;
+	f64 stylus_output_bottom = 1;
+	std::map<StylusBit, u16> stylus_keys {{StylusBit::Button, BTN_STYLUS}};
+	u16 stylus_button_double_key = 0;
+	usize stylus_button_double_window = 300;
+	u16 stylus_button_hold_key = 0;
+	usize stylus_button_hold_time = 500;
+	u16 stylus_wake_key = 0;
+	f64 stylus_smoothing = 0;
+	f64 stylus_tilt_smoothing = 0;
+	f64 stylus_snap_grid = 0;
+	bool stylus_snap_button = false;
+	f64 stylus_contact_min_pressure = 0;
+	f64 stylus_contact_release_pressure = 0;
+	f64 stylus_prediction = 0;
+	f64 stylus_prediction_max_distance = 0.5;
+	bool stylus_raw_timestamp = false;
+	u16 stylus_max_x = ipts::protocol::stylus::MAX_X;
+	u16 stylus_max_y = ipts::protocol::stylus::MAX_Y;
+	u16 stylus_tilt_max = 9000;
+	f64 stylus_altitude_min = 0;
+	f64 stylus_altitude_max = 90;
+	bool stylus_disable_tilt = false;
+	usize stylus_timeout = 0;
+	usize stylus_warmup_reports = 0;
+	usize stylus_rubber_debounce = 0;
+	std::string stylus_record {};
+	usize stylus_record_limit = 0;
+	usize stylus_max_rate = 0;
+	u16 stylus_mpp_1_0_max_pressure = ipts::protocol::stylus::MAX_PRESSURE_MPP_1_0;
+	std::string stylus_report_format = "auto";
+	StylusCalibration stylus_calibration {};
+	std::map<u32, StylusCalibration> stylus_calibrations {};
+	std::vector<u32> stylus_rubber_serials {};
+	bool stylus_rubber_tool = true;
+	bool stylus_multitouch = false;
+	bool stylus_emit_serial = false;
+	bool stylus_emit_timestamp = false;
+	f64 stylus_timestamp_unit = 0;
+	std::vector<u16> stylus_rubber_keys {};
+
+	// [Uinput]
+	std::string uinput_touch_name = "IPTS Touch";
+	std::string uinput_stylus_name = "IPTS Stylus";
+	std::string uinput_singletouch_name = "IPTS Single Touch";
+	std::string uinput_keyboard_name = "IPTS Keyboard";
+	std::string uinput_scroll_name = "IPTS Scroll";
+	std::string uinput_touchpad_name = "IPTS Touchpad";
+	u16 uinput_vendor = 0;
+	u16 uinput_product = 0;
+	u16 uinput_version = 0;
 
 	// [DFT]
 	usize dft_position_min_amp = 50;
@@ -62,6 +206,144 @@ public:
 	f64 dft_tilt_distance = 0.6;
 
 public:
+	/*!
+	 * Whether the configured rotation exchanges the X and Y axis of the output.
+	 *
+	 * @return true if the output is rotated by 90 or 270 degrees.
+	 */
+	[[nodiscard]] bool rotates_axes() const
+	{
+		return this->rotation == 90 || this->rotation == 270;
+	}
+
+	/*!
+	 * Whether the X and Y axis of the output are exchanged, by rotation or by SwapXY.
+	 *
+	 * @return true if the axes of the output are exchanged.
+	 */
+	[[nodiscard]] bool swaps_axes() const
+	{
+		return this->rotates_axes() != this->swap_xy;
+	}
+
+	/*!
+	 * The physical width of the output, after rotation has been applied.
+	 *
+	 * @return The width of the output in centimeters.
+	 */
+	[[nodiscard]] f64 output_width() const
+	{
+		return this->swaps_axes() ? this->height : this->width;
+	}
+
+	/*!
+	 * The physical height of the output, after rotation has been applied.
+	 *
+	 * @return The height of the output in centimeters.
+	 */
+	[[nodiscard]] f64 output_height() const
+	{
+		return this->swaps_axes() ? this->width : this->height;
+	}
+
+	/*!
+	 * The calibration of a stylus.
+	 *
+	 * @param[in] serial The serial number of the stylus.
+	 * @return The calibration of the stylus, or the default one if it has none.
+	 */
+	[[nodiscard]] const StylusCalibration &calibration(const u32 serial) const
+	{
+		const auto it = this->stylus_calibrations.find(serial);
+
+		if (it == this->stylus_calibrations.cend())
+			return this->stylus_calibration;
+
+		return it->second;
+	}
+
+	/*!
+	 * The key that is emitted for a bit of the stylus state.
+	 *
+	 * @param[in] bit The bit of the stylus state.
+	 * @return The key code from Stylus/KeyMap, or 0 if the bit is not mapped to a key.
+	 */
+	[[nodiscard]] u16 stylus_key(const StylusBit bit) const
+	{
+		const auto it = this->stylus_keys.find(bit);
+
+		if (it == this->stylus_keys.cend())
+			return 0;
+
+		return it->second;
+	}
+
+	/*!
+	 * Whether the eraser is reported as a separate tool (BTN_TOOL_RUBBER).
+	 *
+	 * @return false if it was disabled, or if the rubber bit is mapped to a key instead.
+	 */
+	[[nodiscard]] bool reports_rubber_tool() const
+	{
+		return this->stylus_rubber_tool && this->stylus_key(StylusBit::Rubber) == 0;
+	}
+
+	/*!
+	 * Whether a stylus is the eraser of a pen that reports it with its own serial number.
+	 *
+	 * @param[in] serial The serial number of the stylus.
+	 * @return Whether the stylus is listed in the Stylus/RubberSerials option.
+	 */
+	[[nodiscard]] bool is_rubber(const u32 serial) const
+	{
+		const auto &serials = this->stylus_rubber_serials;
+		return std::find(serials.cbegin(), serials.cend(), serial) != serials.cend();
+	}
+
+	/*!
+	 * Applies the affine transform from the Config/Transform option to a position,
+	 * followed by the factor from the Config/CoordScale option.
+	 *
+	 * @param[in] position The normalized position, in the coordinate space of the digitizer.
+	 * @return The transformed position.
+	 */
+	[[nodiscard]] Vector2<f64> transform_position(const Vector2<f64> &position) const
+	{
+		const std::vector<f64> &t = this->transform;
+
+		if (t.size() != 6)
+			return position * this->coord_scale;
+
+		const f64 x = t.at(0) * position.x() + t.at(1) * position.y() + t.at(2);
+		const f64 y = t.at(3) * position.x() + t.at(4) * position.y() + t.at(5);
+
+		return Vector2<f64> {x, y} * this->coord_scale;
+	}
+
+	/*!
+	 * Applies the profile of the current orientation, if there is one.
+	 *
+	 * @return The configuration, with the options of the profile replacing the regular ones.
+	 */
+	[[nodiscard]] Config oriented() const
+	{
+		Config config = *this;
+
+		const auto it = this->orientations.find(this->orientation);
+
+		if (it == this->orientations.cend())
+			return config;
+
+		const OrientationProfile &profile = it->second;
+
+		config.rotation = profile.rotation;
+		config.screen_width = profile.screen_width;
+		config.screen_height = profile.screen_height;
+		config.stylus_calibration = profile.calibration;
+
+		return config;
+	}
+
 	/*!
 	 * Generates a configuration object for the contact detection library.
 	 *
@@ -77,6 +359,8 @@ public:
 		config.detection.normalize = true;
 		config.detection.activation_threshold = athresh / 255.0;
 		config.detection.deactivation_threshold = dthresh / 255.0;
+		config.detection.merge_threshold = this->contacts_merge_threshold;
+		config.detection.split_threshold = this->contacts_split_threshold;
 
 		using Algorithm = contacts::detection::neutral::Algorithm;
 
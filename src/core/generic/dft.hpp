@@ -47,6 +47,16 @@ public:
 		: m_config {std::move(config)},
 		  m_metadata {metadata} {};
 
+	/*!
+	 * Replaces the configuration that is used for processing DFT windows.
+	 *
+	 * @param[in] config The new configuration.
+	 */
+	void reload(const Config &config)
+	{
+		m_config = config;
+	}
+
 	/*!
 	 * Loads a DFT window and calculates stylus properties from it.
 	 *
@@ -166,6 +176,7 @@ private:
 
 				m_stylus.azimuth = azm;
 				m_stylus.altitude = alt;
+				m_stylus.has_tilt = true;
 			}
 		}
 
@@ -12,6 +12,28 @@ namespace iptsd::core {
 enum class Error : u8 {
 	InvalidScreenSize,
 	InvalidNeutralValueAlgorithm,
+	InvalidPressureRange,
+	InvalidRotation,
+	InvalidSmoothing,
+	InvalidScreenResolution,
+	InvalidStylusRange,
+	InvalidTiltRange,
+	InvalidMaxPressure,
+	InvalidOrientation,
+	InvalidHandedness,
+	InvalidReportFormat,
+	InvalidMergeThreshold,
+	InvalidScrollDistance,
+	InvalidReleasePressure,
+	InvalidTouchpadRegion,
+	InvalidTouchpadSpeed,
+	InvalidTouchPressure,
+	InvalidSnapGrid,
+	InvalidPrediction,
+	InvalidOutputRegion,
+	InvalidPressureOffset,
+	InvalidCoordScale,
+	InvalidAltitudeRange,
 };
 
 inline std::string format_as(Error err)
@@ -21,6 +43,50 @@ inline std::string format_as(Error err)
 		return "core: The screen size is 0! Is your device supported?";
 	case Error::InvalidNeutralValueAlgorithm:
 		return "core: The selected neutral value algorithm is invalid!";
+	case Error::InvalidPressureRange:
+		return "core: The stylus pressure range is invalid!";
+	case Error::InvalidRotation:
+		return "core: The rotation must be one of 0, 90, 180 or 270 degrees!";
+	case Error::InvalidSmoothing:
+		return "core: The smoothing factor must be in the range [0, 1)!";
+	case Error::InvalidScreenResolution:
+		return "core: The screen resolution must have both a width and a height!";
+	case Error::InvalidStylusRange:
+		return "core: The logical range of the stylus coordinates is 0!";
+	case Error::InvalidTiltRange:
+		return "core: The range of the stylus tilt is 0!";
+	case Error::InvalidMaxPressure:
+		return "core: The maximum pressure of the stylus is 0!";
+	case Error::InvalidOrientation:
+		return "core: The orientation must be one of 0, 90, 180 or 270 degrees!";
+	case Error::InvalidHandedness:
+		return "core: The handedness must be one of none, right or left!";
+	case Error::InvalidReportFormat:
+		return "core: The stylus report format must be one of auto, mpp-1.0 or mpp-1.51!";
+	case Error::InvalidMergeThreshold:
+		return "core: The split threshold must be between 0 and the merge threshold!";
+	case Error::InvalidScrollDistance:
+		return "core: The scroll distance must be larger than 0!";
+	case Error::InvalidReleasePressure:
+		return "core: The stylus release pressure must not exceed the contact pressure!";
+	case Error::InvalidTouchpadRegion:
+		return "core: The touchpad region must be a non-empty area inside of [0, 1]!";
+	case Error::InvalidTouchpadSpeed:
+		return "core: The touchpad speed must be larger than 0!";
+	case Error::InvalidTouchPressure:
+		return "core: The touch pressure intensity and maximum must be larger than 0!";
+	case Error::InvalidSnapGrid:
+		return "core: The spacing of the snap grid must not be negative!";
+	case Error::InvalidPrediction:
+		return "core: Stylus prediction requires the unit of the stylus timestamp!";
+	case Error::InvalidOutputRegion:
+		return "core: The stylus output region must be a non-empty area inside of [0, 1]!";
+	case Error::InvalidPressureOffset:
+		return "core: The pressure offset must be in the range [0, 1)!";
+	case Error::InvalidCoordScale:
+		return "core: The coordinate scale must be larger than 0!";
+	case Error::InvalidAltitudeRange:
+		return "core: The stylus altitude range must be inside of [0, 90] degrees!";
 	default:
 		return "core: Invalid error code!";
 	}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_CORE_GENERIC_STATISTICS_HPP
+#define IPTSD_CORE_GENERIC_STATISTICS_HPP
+
+#include <common/chrono.hpp>
+#include <common/types.hpp>
+
+#include <map>
+
+namespace iptsd::core {
+
+/*
+ * Counters for the data that was processed by an application.
+ */
+class Statistics {
+public:
+	// How many buffers were received from the device.
+	usize frames = 0;
+
+	// How many buffers were dropped because of parsing errors.
+	usize dropped = 0;
+
+	// How many stylus, heatmap and DFT reports were processed.
+	usize stylus = 0;
+	usize heatmaps = 0;
+	usize dft = 0;
+
+	// How often each type of report was encountered.
+	std::map<u32, usize> reports {};
+
+	// How often each type of unknown frame was skipped.
+	std::map<u32, usize> unknown {};
+
+	// When counting was started.
+	chrono::steady_clock::time_point start = chrono::steady_clock::now();
+
+public:
+	/*!
+	 * Resets all counters and starts counting again.
+	 */
+	void reset()
+	{
+		*this = Statistics {};
+	}
+
+	/*!
+	 * How much time has passed since counting was started.
+	 *
+	 * @return The elapsed time in seconds.
+	 */
+	[[nodiscard]] f64 elapsed() const
+	{
+		return seconds<f64> {chrono::steady_clock::now() - this->start}.count();
+	}
+};
+
+} // namespace iptsd::core
+
+#endif // IPTSD_CORE_GENERIC_STATISTICS_HPP
@@ -16,8 +16,14 @@
 #include <fmt/format.h>
 #include <spdlog/spdlog.h>
 
+#include <linux/input-event-codes.h>
+
+#include <cmath>
+#include <cstdint>
+#include <cstdlib>
 #include <filesystem>
 #include <optional>
+#include <sstream>
 #include <string>
 #include <type_traits>
 
@@ -51,8 +57,14 @@ public:
 		 * known working main system configuration.
 		 */
 		if (const char *config_file_path = std::getenv("IPTSD_CONFIG_FILE")) {
-			this->load_file(config_file_path);
-			return;
+			if (std::filesystem::exists(config_file_path)) {
+				this->load_file(config_file_path);
+				return;
+			}
+
+			// Don't leave the device unconfigured because the custom config is missing.
+			spdlog::warn("Config {} does not exist, falling back to the system config.",
+			             config_file_path);
 		}
 
 		if (std::filesystem::exists(common::buildopts::ConfigFile))
@@ -67,11 +79,11 @@ public:
 	/*!
 	 * The loaded config object.
 	 *
-	 * @return The configuration data that was loaded for the given device.
+	 * @return The configuration data that was loaded for the given device and orientation.
 	 */
 	[[nodiscard]] Config config() const
 	{
-		return m_config;
+		return m_config.oriented();
 	}
 
 private:
@@ -144,18 +156,49 @@ private:
 
 		this->get(ini, "Config", "InvertX", m_config.invert_x);
 		this->get(ini, "Config", "InvertY", m_config.invert_y);
+		this->get(ini, "Config", "SwapXY", m_config.swap_xy);
 		this->get(ini, "Config", "Width", m_config.width);
 		this->get(ini, "Config", "Height", m_config.height);
+		this->get(ini, "Config", "Rotation", m_config.rotation);
+		this->get(ini, "Config", "ScreenWidth", m_config.screen_width);
+		this->get(ini, "Config", "ScreenHeight", m_config.screen_height);
+		this->get(ini, "Config", "StatisticsInterval", m_config.statistics_interval);
+		this->get(ini, "Config", "ParseErrorLimit", m_config.parse_error_limit);
+		this->get(ini, "Config", "Watchdog", m_config.watchdog);
+		this->get(ini, "Config", "CoordScale", m_config.coord_scale);
+		this->get(ini, "Config", "Orientation", m_config.orientation);
 
 		this->get(ini, "Touch", "Disable", m_config.touch_disable);
 		this->get(ini, "Touch", "DisableOnPalm", m_config.touch_disable_on_palm);
+		this->get(ini, "Touch", "PalmMaxContacts", m_config.touch_palm_max_contacts);
+		this->get(ini, "Touch", "PalmMaxArea", m_config.touch_palm_max_area);
+		this->get(ini, "Touch", "ReportPalms", m_config.touch_report_palms);
 		this->get(ini, "Touch", "DisableOnStylus", m_config.touch_disable_on_stylus);
+		this->get(ini, "Touch", "DisableNearStylus", m_config.touch_disable_near_stylus);
+		this->get(ini, "Touch", "Handedness", m_config.touch_handedness);
 		this->get(ini, "Touch", "Overshoot", m_config.touch_overshoot);
+		this->get(ini, "Touch", "MinLifetime", m_config.touch_min_lifetime);
+		this->get(ini, "Touch", "Smoothing", m_config.touch_smoothing);
+		this->get(ini, "Touch", "SingleTouch", m_config.touch_singletouch);
+		this->get(ini, "Touch", "Scroll", m_config.touch_scroll);
+		this->get(ini, "Touch", "ScrollDistance", m_config.touch_scroll_distance);
+		this->get(ini, "Touch", "Pressure", m_config.touch_pressure);
+		this->get(ini, "Touch", "PressureIntensity", m_config.touch_pressure_intensity);
+		this->get(ini, "Touch", "PressureMax", m_config.touch_pressure_max);
+		this->get(ini, "Touch", "Touchpad", m_config.touch_touchpad);
+		this->get(ini, "Touch", "TouchpadLeft", m_config.touch_touchpad_left);
+		this->get(ini, "Touch", "TouchpadTop", m_config.touch_touchpad_top);
+		this->get(ini, "Touch", "TouchpadRight", m_config.touch_touchpad_right);
+		this->get(ini, "Touch", "TouchpadBottom", m_config.touch_touchpad_bottom);
+		this->get(ini, "Touch", "TouchpadSpeed", m_config.touch_touchpad_speed);
+		this->get(ini, "Touch", "Mouse", m_config.touch_mouse);
 
 		this->get(ini, "Contacts", "Neutral", m_config.contacts_neutral);
 		this->get(ini, "Contacts", "NeutralValue", m_config.contacts_neutral_value);
 		this->get(ini, "Contacts", "ActivationThreshold", m_config.contacts_activation_threshold);
 		this->get(ini, "Contacts", "DeactivationThreshold", m_config.contacts_deactivation_threshold);
+		this->get(ini, "Contacts", "MergeThreshold", m_config.contacts_merge_threshold);
+		this->get(ini, "Contacts", "SplitThreshold", m_config.contacts_split_threshold);
 		this->get(ini, "Contacts", "SizeThresholdMin", m_config.contacts_size_thresh_min);
 		this->get(ini, "Contacts", "SizeThresholdMax", m_config.contacts_size_thresh_max);
 		this->get(ini, "Contacts", "PositionThresholdMin", m_config.contacts_position_thresh_min);
@@ -168,7 +211,68 @@ private:
 		this->get(ini, "Contacts", "AspectMax", m_config.contacts_aspect_max);
 
 		this->get(ini, "Stylus", "Disable", m_config.stylus_disable);
+		this->get(ini, "Stylus", "InvertX", m_config.stylus_invert_x);
+		this->get(ini, "Stylus", "InvertY", m_config.stylus_invert_y);
+		this->get(ini, "Stylus", "InvertPressure", m_config.stylus_invert_pressure);
+		this->get(ini, "Stylus", "DisableHover", m_config.stylus_disable_hover);
 		this->get(ini, "Stylus", "TipDistance", m_config.stylus_tip_distance);
+		this->get(ini, "Stylus", "PressureMin", m_config.stylus_pressure_min);
+		this->get(ini, "Stylus", "PressureMax", m_config.stylus_pressure_max);
+		this->get(ini, "Stylus", "PressureGamma", m_config.stylus_pressure_gamma);
+		this->get(ini, "Stylus", "PressureOffset", m_config.stylus_pressure_offset);
+		this->get(ini, "Stylus", "OutputPressureMin", m_config.stylus_output_pressure_min);
+		this->get(ini, "Stylus", "OutputPressureMax", m_config.stylus_output_pressure_max);
+		this->get(ini, "Stylus", "OutputLeft", m_config.stylus_output_left);
+		this->get(ini, "Stylus", "OutputTop", m_config.stylus_output_top);
+		this->get(ini, "Stylus", "OutputRight", m_config.stylus_output_right);
+		this->get(ini, "Stylus", "OutputBottom", m_config.stylus_output_bottom);
+		this->get(ini, "Stylus", "ButtonDoubleKey", m_config.stylus_button_double_key);
+		this->get(ini, "Stylus", "ButtonDoubleWindow", m_config.stylus_button_double_window);
+		this->get(ini, "Stylus", "ButtonHoldKey", m_config.stylus_button_hold_key);
+		this->get(ini, "Stylus", "ButtonHoldTime", m_config.stylus_button_hold_time);
+		this->get(ini, "Stylus", "WakeKey", m_config.stylus_wake_key);
+		this->get(ini, "Stylus", "Smoothing", m_config.stylus_smoothing);
+		this->get(ini, "Stylus", "TiltSmoothing", m_config.stylus_tilt_smoothing);
+		this->get(ini, "Stylus", "SnapGrid", m_config.stylus_snap_grid);
+		this->get(ini, "Stylus", "SnapButton", m_config.stylus_snap_button);
+		this->get(ini, "Stylus", "ContactMinPressure", m_config.stylus_contact_min_pressure);
+		this->get(ini, "Stylus", "ContactReleasePressure", m_config.stylus_contact_release_pressure);
+		this->get(ini, "Stylus", "Prediction", m_config.stylus_prediction);
+		this->get(ini, "Stylus", "PredictionMaxDistance", m_config.stylus_prediction_max_distance);
+		this->get(ini, "Stylus", "RawTimestamp", m_config.stylus_raw_timestamp);
+		this->get(ini, "Stylus", "MaxX", m_config.stylus_max_x);
+		this->get(ini, "Stylus", "MaxY", m_config.stylus_max_y);
+		this->get(ini, "Stylus", "TiltMax", m_config.stylus_tilt_max);
+		this->get(ini, "Stylus", "AltitudeMin", m_config.stylus_altitude_min);
+		this->get(ini, "Stylus", "AltitudeMax", m_config.stylus_altitude_max);
+		this->get(ini, "Stylus", "DisableTilt", m_config.stylus_disable_tilt);
+		this->get(ini, "Stylus", "Timeout", m_config.stylus_timeout);
+		this->get(ini, "Stylus", "WarmupReports", m_config.stylus_warmup_reports);
+		this->get(ini, "Stylus", "RubberDebounce", m_config.stylus_rubber_debounce);
+		this->get(ini, "Stylus", "Record", m_config.stylus_record);
+		this->get(ini, "Stylus", "RecordLimit", m_config.stylus_record_limit);
+		this->get(ini, "Stylus", "MaxRate", m_config.stylus_max_rate);
+		this->get(ini, "Stylus", "Mpp10MaxPressure", m_config.stylus_mpp_1_0_max_pressure);
+		this->get(ini, "Stylus", "ReportFormat", m_config.stylus_report_format);
+		this->get(ini, "Stylus", "OffsetX", m_config.stylus_calibration.offset_x);
+		this->get(ini, "Stylus", "OffsetY", m_config.stylus_calibration.offset_y);
+		this->get(ini, "Stylus", "ScaleX", m_config.stylus_calibration.scale_x);
+		this->get(ini, "Stylus", "ScaleY", m_config.stylus_calibration.scale_y);
+		this->get(ini, "Stylus", "RubberTool", m_config.stylus_rubber_tool);
+		this->get(ini, "Stylus", "MultiTouch", m_config.stylus_multitouch);
+		this->get(ini, "Stylus", "EmitSerial", m_config.stylus_emit_serial);
+		this->get(ini, "Stylus", "EmitTimestamp", m_config.stylus_emit_timestamp);
+		this->get(ini, "Stylus", "TimestampUnit", m_config.stylus_timestamp_unit);
+
+		this->get(ini, "Uinput", "TouchName", m_config.uinput_touch_name);
+		this->get(ini, "Uinput", "StylusName", m_config.uinput_stylus_name);
+		this->get(ini, "Uinput", "SingleTouchName", m_config.uinput_singletouch_name);
+		this->get(ini, "Uinput", "KeyboardName", m_config.uinput_keyboard_name);
+		this->get(ini, "Uinput", "ScrollName", m_config.uinput_scroll_name);
+		this->get(ini, "Uinput", "TouchpadName", m_config.uinput_touchpad_name);
+		this->get(ini, "Uinput", "Vendor", m_config.uinput_vendor);
+		this->get(ini, "Uinput", "Product", m_config.uinput_product);
+		this->get(ini, "Uinput", "Version", m_config.uinput_version);
 
 		this->get(ini, "DFT", "PositionMinAmp", m_config.dft_position_min_amp);
 		this->get(ini, "DFT", "PositionMinMag", m_config.dft_position_min_mag);
@@ -185,9 +289,290 @@ private:
 		this->get(ini, "Contacts", "SizeThreshold", m_config.contacts_size_thresh_max);
 
 		// clang-format on
+
+		this->load_calibrations(ini);
+		this->load_orientations(ini);
+		this->load_key_map(ini);
+		this->load_rubber_keys(ini);
+		this->load_rubber_serials(ini);
+		this->load_sources(ini);
+		this->load_transform(ini);
 		m_loaded_config = true;
 	}
 
+	/*!
+	 * Loads the calibration of all styli that are listed in the Stylus/Serials option.
+	 *
+	 * Every stylus is calibrated in its own section, which is named after its serial number
+	 * (e.g. [Stylus.0x12345678]). Options that are missing from that section are inherited
+	 * from the default calibration in the [Stylus] section.
+	 *
+	 * @param[in] ini The loaded file.
+	 */
+	void load_calibrations(const INIReader &ini)
+	{
+		std::string serials {};
+		this->get(ini, "Stylus", "Serials", serials);
+
+		std::istringstream stream {serials};
+		std::string serial {};
+
+		while (stream >> serial) {
+			const std::string section = "Stylus." + serial;
+			const u32 key = ConfigLoader::parse_serial(serial);
+
+			const auto it = m_config.stylus_calibrations.find(key);
+			StylusCalibration calibration = m_config.stylus_calibration;
+
+			// Keep the values that were loaded from previous files.
+			if (it != m_config.stylus_calibrations.cend())
+				calibration = it->second;
+
+			this->get(ini, section, "OffsetX", calibration.offset_x);
+			this->get(ini, section, "OffsetY", calibration.offset_y);
+			this->get(ini, section, "ScaleX", calibration.scale_x);
+			this->get(ini, section, "ScaleY", calibration.scale_y);
+
+			m_config.stylus_calibrations[key] = calibration;
+		}
+	}
+
+	/*!
+	 * Loads the profiles of all orientations that are listed in the Config/Orientations option.
+	 *
+	 * Every profile is loaded from its own section, which is named after the orientation
+	 * (e.g. [Orientation.90]). Options that are missing from that section are inherited
+	 * from the regular options.
+	 *
+	 * @param[in] ini The loaded file.
+	 */
+	void load_orientations(const INIReader &ini)
+	{
+		std::string orientations {};
+		this->get(ini, "Config", "Orientations", orientations);
+
+		std::istringstream stream {orientations};
+		std::string orientation {};
+
+		while (stream >> orientation) {
+			char *end = nullptr;
+			const unsigned long value = std::strtoul(orientation.c_str(), &end, 10);
+			const bool valid = value % 90 == 0 && value < 360;
+
+			if (end == orientation.c_str() || *end != '\0' || !valid)
+				throw common::Error<Error::ParsingInvalidOrientation> {orientation};
+
+			const std::string section = "Orientation." + orientation;
+			const u16 key = casts::to<u16>(value);
+
+			const auto it = m_config.orientations.find(key);
+			OrientationProfile profile {};
+
+			profile.rotation = m_config.rotation;
+			profile.screen_width = m_config.screen_width;
+			profile.screen_height = m_config.screen_height;
+			profile.calibration = m_config.stylus_calibration;
+
+			// Keep the values that were loaded from previous files.
+			if (it != m_config.orientations.cend())
+				profile = it->second;
+
+			StylusCalibration &calibration = profile.calibration;
+
+			this->get(ini, section, "Rotation", profile.rotation);
+			this->get(ini, section, "ScreenWidth", profile.screen_width);
+			this->get(ini, section, "ScreenHeight", profile.screen_height);
+			this->get(ini, section, "OffsetX", calibration.offset_x);
+			this->get(ini, section, "OffsetY", calibration.offset_y);
+			this->get(ini, section, "ScaleX", calibration.scale_x);
+			this->get(ini, section, "ScaleY", calibration.scale_y);
+
+			m_config.orientations[key] = profile;
+		}
+	}
+
+	/*!
+	 * Loads the key combination that is pressed when the eraser of the stylus is toggled.
+	 *
+	 * The keys are listed in the Stylus/RubberKeys option, as key codes separated by spaces.
+	 *
+	 * @param[in] ini The loaded file.
+	 */
+	void load_rubber_keys(const INIReader &ini)
+	{
+		std::string keys {};
+		this->get(ini, "Stylus", "RubberKeys", keys);
+
+		// Don't replace the keys from previous files if this one doesn't set any.
+		if (keys.empty())
+			return;
+
+		std::istringstream stream {keys};
+		std::string key {};
+
+		m_config.stylus_rubber_keys.clear();
+
+		while (stream >> key)
+			m_config.stylus_rubber_keys.push_back(ConfigLoader::parse_key(key));
+	}
+
+	/*!
+	 * Loads the keys that the bits of the stylus state are mapped to.
+	 *
+	 * The Stylus/KeyMap option lists pairs of a bit and a key code, e.g. "button:331".
+	 * Bits that are not listed keep the key from previous files, or their default.
+	 *
+	 * @param[in] ini The loaded file.
+	 */
+	void load_key_map(const INIReader &ini)
+	{
+		std::string map {};
+		this->get(ini, "Stylus", "KeyMap", map);
+
+		std::istringstream stream {map};
+		std::string entry {};
+
+		while (stream >> entry) {
+			const usize split = entry.find(':');
+
+			if (split == std::string::npos)
+				throw common::Error<Error::ParsingInvalidKeyMapping> {entry};
+
+			const std::string bit = entry.substr(0, split);
+			const u16 key = ConfigLoader::parse_key(entry.substr(split + 1));
+
+			if (bit == "button")
+				m_config.stylus_keys[StylusBit::Button] = key;
+			else if (bit == "rubber")
+				m_config.stylus_keys[StylusBit::Rubber] = key;
+			else
+				throw common::Error<Error::ParsingInvalidKeyMapping> {entry};
+		}
+	}
+
+	/*!
+	 * Parses a key code for a uinput device.
+	 *
+	 * @param[in] key The key code, in decimal or hexadecimal notation.
+	 * @return The key code, if it is a valid key other than KEY_RESERVED.
+	 */
+	[[nodiscard]] static u16 parse_key(const std::string &key)
+	{
+		char *end = nullptr;
+		const unsigned long value = std::strtoul(key.c_str(), &end, 0);
+
+		if (end == key.c_str() || *end != '\0' || value == 0 || value > KEY_MAX)
+			throw common::Error<Error::ParsingInvalidKey> {key};
+
+		return casts::to<u16>(value);
+	}
+
+	/*!
+	 * Loads the serial numbers of the styli that are erasers.
+	 *
+	 * The serials are listed in the Stylus/RubberSerials option, separated by spaces.
+	 *
+	 * @param[in] ini The loaded file.
+	 */
+	void load_rubber_serials(const INIReader &ini)
+	{
+		std::string serials {};
+		this->get(ini, "Stylus", "RubberSerials", serials);
+
+		// Don't replace the serials from previous files if this one doesn't set any.
+		if (serials.empty())
+			return;
+
+		std::istringstream stream {serials};
+		std::string serial {};
+
+		m_config.stylus_rubber_serials.clear();
+
+		while (stream >> serial) {
+			const u32 value = ConfigLoader::parse_serial(serial);
+			m_config.stylus_rubber_serials.push_back(value);
+		}
+	}
+
+	/*!
+	 * Parses the serial number of a stylus.
+	 *
+	 * @param[in] serial The serial number, in decimal or hexadecimal (e.g. 0x12345678).
+	 * @return The parsed serial number.
+	 */
+	[[nodiscard]] static u32 parse_serial(const std::string &serial)
+	{
+		char *end = nullptr;
+		const unsigned long value = std::strtoul(serial.c_str(), &end, 0);
+
+		if (end == serial.c_str() || *end != '\0' || value > UINT32_MAX)
+			throw common::Error<Error::ParsingInvalidSerial> {serial};
+
+		return casts::to<u32>(value);
+	}
+
+	/*!
+	 * Loads the affine transform that is applied to all positions.
+	 *
+	 * The transform is given in the Config/Transform option, as the six values of the
+	 * first two rows of the matrix, separated by spaces.
+	 *
+	 * @param[in] ini The loaded file.
+	 */
+	void load_transform(const INIReader &ini)
+	{
+		std::string transform {};
+		this->get(ini, "Config", "Transform", transform);
+
+		// Don't replace the transform from previous files if this one doesn't set any.
+		if (transform.empty())
+			return;
+
+		std::istringstream stream {transform};
+		std::string value {};
+		std::vector<f64> values {};
+
+		while (stream >> value) {
+			char *end = nullptr;
+			const f64 parsed = std::strtod(value.c_str(), &end);
+
+			if (end == value.c_str() || *end != '\0' || !std::isfinite(parsed))
+				throw common::Error<Error::ParsingInvalidTransform> {transform};
+
+			values.push_back(parsed);
+		}
+
+		if (values.size() != 6)
+			throw common::Error<Error::ParsingInvalidTransform> {transform};
+
+		m_config.transform = values;
+	}
+
+	/*!
+	 * Loads the additional hidraw devices that send data for the touchscreen.
+	 *
+	 * The devices are listed in the Config/Sources option, as paths separated by spaces.
+	 *
+	 * @param[in] ini The loaded file.
+	 */
+	void load_sources(const INIReader &ini)
+	{
+		std::string sources {};
+		this->get(ini, "Config", "Sources", sources);
+
+		// Don't replace the sources from previous files if this one doesn't set any.
+		if (sources.empty())
+			return;
+
+		std::istringstream stream {sources};
+		std::string source {};
+
+		m_config.sources.clear();
+
+		while (stream >> source)
+			m_config.sources.push_back(source);
+	}
+
 	/*!
 	 * Loads a value from a config file.
 	 *
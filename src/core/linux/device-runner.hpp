@@ -19,6 +19,8 @@
 #include <atomic>
 #include <filesystem>
 #include <memory>
+#include <mutex>
+#include <string>
 #include <thread>
 #include <type_traits>
 #include <vector>
@@ -30,19 +32,101 @@ class DeviceRunner {
 private:
 	static_assert(std::is_base_of_v<Application, T>);
 
+	/*
+	 * How long to wait for data before the application is informed that the device is idle.
+	 */
+	constexpr static chrono::milliseconds IDLE_TIMEOUT = 50ms;
+
+	/*
+	 * An additional device that sends data for the same touchscreen.
+	 */
+	struct Source {
+		// The hidraw device node of the source.
+		std::filesystem::path path;
+
+		// The hidraw device serving as the source of data.
+		std::shared_ptr<HidrawDevice> device;
+
+		// The IPTS touchscreen interface of the device.
+		ipts::Device ipts;
+
+		// The target buffer for reading HID reports.
+		std::vector<u8> buffer {};
+
+		// The number of the source, counting from 1. The main device is 0.
+		usize index;
+
+		Source(const std::filesystem::path &path, const usize number)
+			: path {path},
+			  device {std::make_shared<HidrawDevice>(path)},
+			  ipts {device},
+			  index {number}
+		{
+			buffer.resize(casts::to<usize>(ipts.buffer_size()));
+		}
+	};
+
 private:
+	/*
+	 * The requests from signal handlers are shared by the whole process instead of belonging
+	 * to a runner. This way, a signal handler never touches a runner that is being destroyed
+	 * or created (e.g. while the daemon reconnects to the device), and no request gets lost.
+	 */
+
+	// Whether the loop for reading from the device should stop.
+	// NOLINTNEXTLINE(cppcoreguidelines-avoid-non-const-global-variables)
+	inline static std::atomic_bool s_should_stop = false;
+
+	// Whether the configuration should be reloaded before processing the next buffer.
+	// NOLINTNEXTLINE(cppcoreguidelines-avoid-non-const-global-variables)
+	inline static std::atomic_bool s_should_reload = false;
+
+	// Whether touch or stylus input should be switched on or off before the next buffer.
+	// NOLINTNEXTLINE(cppcoreguidelines-avoid-non-const-global-variables)
+	inline static std::atomic_bool s_should_toggle_touch = false;
+	// NOLINTNEXTLINE(cppcoreguidelines-avoid-non-const-global-variables)
+	inline static std::atomic_bool s_should_toggle_stylus = false;
+
+private:
+	// The hidraw device node of the touchscreen.
+	std::filesystem::path m_path;
+
 	// The hidraw device serving as the source of data.
 	std::shared_ptr<HidrawDevice> m_device;
 
 	// The IPTS touchscreen interface
 	ipts::Device m_ipts;
 
-	// Whether the loop for reading from the device should stop.
-	std::atomic_bool m_should_stop = false;
+	// Information about the device.
+	DeviceInfo m_info {};
+
+	// The IPTS device metadata, if the device has it.
+	std::optional<const ipts::Metadata> m_metadata = std::nullopt;
 
 	// The target buffer for reading HID reports.
 	std::vector<u8> m_buffer {};
 
+	// The additional devices, which are read by their own threads.
+	std::vector<std::unique_ptr<Source>> m_sources {};
+
+	// Whether the threads reading from the additional devices should keep running.
+	std::atomic_bool m_running = false;
+
+	// Whether reading from one of the additional devices failed.
+	std::atomic_bool m_source_failed = false;
+
+	// How many buffers in a row may fail to parse before the device is opened again.
+	std::atomic<usize> m_parse_error_limit = 0;
+
+	// How many seconds the device may stay silent before it is opened again, 0 to never.
+	std::atomic<usize> m_watchdog = 0;
+
+	// When one of the devices sent data for the last time.
+	std::atomic<chrono::steady_clock::time_point> m_last_data = chrono::steady_clock::now();
+
+	// Makes sure that the application only processes data from one device at a time.
+	std::mutex m_lock {};
+
 	/*
 	 * deferred initialization
 	 */
@@ -53,25 +137,33 @@ private:
 public:
 	template <class... Args>
 	DeviceRunner(const std::filesystem::path &path, Args... args)
-		: m_device {std::make_shared<HidrawDevice>(path)},
-		  m_ipts {m_device}
+		: m_path {path},
+		  m_device {std::make_shared<HidrawDevice>(path)},
+		  m_ipts {m_device},
+		  m_metadata {m_ipts.metadata()}
 	{
-		DeviceInfo info {};
-		info.vendor = m_device->vendor();
-		info.product = m_device->product();
-		info.buffer_size = m_ipts.buffer_size();
-
-		const std::optional<const ipts::Metadata> meta = m_ipts.metadata();
+		m_info.vendor = m_device->vendor();
+		m_info.product = m_device->product();
+		m_info.buffer_size = m_ipts.buffer_size();
 
-		const ConfigLoader loader {info, meta};
-		m_application.emplace(loader.config(), info, meta, args...);
+		const ConfigLoader loader {m_info, m_metadata};
+		m_application.emplace(loader.config(), m_info, m_metadata, args...);
+		m_parse_error_limit = loader.config().parse_error_limit;
+		m_watchdog = loader.config().watchdog;
 
-		m_buffer.resize(casts::to<usize>(info.buffer_size));
+		m_buffer.resize(casts::to<usize>(m_info.buffer_size));
 
-		const u16 vendor = info.vendor;
-		const u16 product = info.product;
+		const u16 vendor = m_info.vendor;
+		const u16 product = m_info.product;
 
 		spdlog::info("Connected to device {:04X}:{:04X}", vendor, product);
+
+		for (const std::string &source : loader.config().sources) {
+			const usize index = m_sources.size() + 1;
+
+			m_sources.push_back(std::make_unique<Source>(source, index));
+			spdlog::info("Reading additional data from {}", source);
+		}
 	}
 
 	/*!
@@ -94,9 +186,49 @@ public:
 	 *
 	 * This function is designed to be called from a signal handler (e.g. for Ctrl-C).
 	 */
-	void stop()
+	static void stop()
+	{
+		s_should_stop = true;
+	}
+
+	/*!
+	 * Whether the loop that reads from the device was asked to stop.
+	 *
+	 * @return Whether @ref stop was called.
+	 */
+	[[nodiscard]] static bool stopping()
 	{
-		m_should_stop = true;
+		return s_should_stop;
+	}
+
+	/*!
+	 * Reloads the configuration before the next buffer is processed.
+	 *
+	 * This function is designed to be called from a signal handler (e.g. for SIGHUP).
+	 */
+	static void reload()
+	{
+		s_should_reload = true;
+	}
+
+	/*!
+	 * Switches touch input on or off before the next buffer is processed.
+	 *
+	 * This function is designed to be called from a signal handler (e.g. for SIGUSR1).
+	 */
+	static void toggle_touch()
+	{
+		s_should_toggle_touch = true;
+	}
+
+	/*!
+	 * Switches stylus input on or off before the next buffer is processed.
+	 *
+	 * This function is designed to be called from a signal handler (e.g. for SIGUSR2).
+	 */
+	static void toggle_stylus()
+	{
+		s_should_toggle_stylus = true;
 	}
 
 	/*!
@@ -112,54 +244,309 @@ public:
 		// Enable multitouch mode
 		m_ipts.set_mode(ipts::Mode::Multitouch);
 
+		for (const std::unique_ptr<Source> &source : m_sources)
+			source->ipts.set_mode(ipts::Mode::Multitouch);
+
 		// Signal the application that the data flow has started.
 		m_application->on_start();
 
+		std::vector<std::thread> threads {};
+		this->start_sources(threads);
+
 		usize errors = 0;
+		usize parse_errors = 0;
 
-		while (!m_should_stop) {
+		while (!s_should_stop && !m_source_failed) {
 			if (errors >= 50) {
 				spdlog::error("Encountered 50 continuous errors, aborting...");
 				break;
 			}
 
-			try {
-				const isize size = m_device->read(m_buffer);
-				const gsl::span<u8> data {m_buffer.data(),
-				                          casts::to_unsigned(size)};
-
-				// Does this report contain touch data?
-				if (!m_ipts.is_touch_data(m_buffer))
-					continue;
+			if (this->desynced(parse_errors)) {
+				spdlog::error("Encountered {} malformed buffers, reconnecting...",
+				              parse_errors);
+				break;
+			}
 
-				m_application->process(data);
-			} catch (const std::exception &e) {
-				spdlog::warn(e.what());
+			if (this->stalled()) {
+				const usize timeout = m_watchdog;
+				spdlog::warn("No data for {} seconds, opening the device again",
+				             timeout);
 
-				// Sleep for a moment to let the device get back into normal state.
-				std::this_thread::sleep_for(100ms);
+				if (!this->reopen(threads))
+					break;
 
-				errors++;
 				continue;
 			}
 
-			// Reset error count.
-			errors = 0;
+			// Swap the configuration between two buffers, never while one is processed.
+			if (s_should_reload.exchange(false)) {
+				const std::lock_guard<std::mutex> lock {m_lock};
+				this->reload_config();
+			}
+
+			if (s_should_toggle_touch.exchange(false)) {
+				const std::lock_guard<std::mutex> lock {m_lock};
+				m_application->toggle_touch();
+			}
+
+			if (s_should_toggle_stylus.exchange(false)) {
+				const std::lock_guard<std::mutex> lock {m_lock};
+				m_application->toggle_stylus();
+			}
+
+			const bool read = this->read_buffer(*m_device,
+			                                    m_ipts,
+			                                    m_buffer,
+			                                    0,
+			                                    errors,
+			                                    parse_errors);
+
+			if (!read) {
+				const std::lock_guard<std::mutex> lock {m_lock};
+				m_application->on_idle();
+			}
 		}
 
 		spdlog::info("Stopping");
 
+		this->stop_sources(threads);
+
 		// Signal the application that the data flow has stopped.
 		m_application->on_stop();
 
 		try {
 			// Disable multitouch mode
 			m_ipts.set_mode(ipts::Mode::Singletouch);
+
+			for (const std::unique_ptr<Source> &source : m_sources)
+				source->ipts.set_mode(ipts::Mode::Singletouch);
 		} catch (const std::exception &e) {
 			spdlog::error(e.what());
 		}
 
-		return m_should_stop;
+		return s_should_stop;
+	}
+
+private:
+	/*!
+	 * Reads a buffer from a device and passes it to the application.
+	 *
+	 * @param[in] device The hidraw device to read from.
+	 * @param[in] ipts The IPTS touchscreen interface of the device.
+	 * @param[in] buffer The target buffer for reading HID reports.
+	 * @param[in] source The number of the device, 0 for the main device.
+	 * @param[in,out] errors How many errors were encountered in a row.
+	 * @param[in,out] parse_errors How many buffers in a row could not be parsed.
+	 * @return Whether the device sent data before the timeout expired.
+	 */
+	bool read_buffer(HidrawDevice &device,
+	                 const ipts::Device &ipts,
+	                 std::vector<u8> &buffer,
+	                 const usize source,
+	                 usize &errors,
+	                 usize &parse_errors)
+	{
+		isize size = 0;
+
+		try {
+			if (!device.wait(IDLE_TIMEOUT))
+				return false;
+
+			size = device.read(buffer);
+			m_last_data = chrono::steady_clock::now();
+		} catch (const std::exception &e) {
+			spdlog::warn(e.what());
+
+			// Sleep for a moment to let the device get back into normal state.
+			std::this_thread::sleep_for(100ms);
+
+			errors++;
+			return true;
+		}
+
+		const gsl::span<u8> data {buffer.data(), casts::to_unsigned(size)};
+
+		// Does this report contain touch data?
+		if (!ipts.is_touch_data(data))
+			return true;
+
+		try {
+			const std::lock_guard<std::mutex> lock {m_lock};
+			m_application->process(data, source);
+		} catch (const std::exception &e) {
+			/*
+			 * The data that was read is malformed. Parsing stops at the first
+			 * error, so the rest of the frame is dropped instead of emitting
+			 * garbage. The device itself is fine, there is no need to wait.
+			 */
+			spdlog::warn("Dropping frame: {}", e.what());
+
+			errors++;
+			parse_errors++;
+			return true;
+		}
+
+		// Reset error count.
+		errors = 0;
+		parse_errors = 0;
+		return true;
+	}
+
+	/*!
+	 * Checks whether the devices stopped sending data for longer than the watchdog allows.
+	 *
+	 * Some devices silently stop sending data (e.g. after resuming from suspend), without
+	 * the read ever failing. Opening the device again makes them send data again.
+	 *
+	 * @return Whether the devices should be opened again.
+	 */
+	[[nodiscard]] bool stalled() const
+	{
+		const usize timeout = m_watchdog;
+
+		if (timeout == 0)
+			return false;
+
+		const chrono::steady_clock::time_point last = m_last_data;
+		return chrono::steady_clock::now() - last >= seconds<usize> {timeout};
+	}
+
+	/*!
+	 * Checks whether the data from a device can't be parsed anymore.
+	 *
+	 * If too many buffers in a row are malformed, the device has most likely lost track of
+	 * the data stream, and will keep sending garbage until it is opened again.
+	 *
+	 * @param[in] parse_errors How many buffers in a row could not be parsed.
+	 * @return Whether the device should be opened again.
+	 */
+	[[nodiscard]] bool desynced(const usize parse_errors) const
+	{
+		const usize limit = m_parse_error_limit;
+		return limit != 0 && parse_errors >= limit;
+	}
+
+	/*!
+	 * Closes the hidraw devices and opens them again, without stopping the application.
+	 *
+	 * The application keeps its input devices, only the inputs that are active are released.
+	 *
+	 * @param[in,out] threads The threads that read from the additional devices.
+	 * @return Whether the devices could be opened again.
+	 */
+	bool reopen(std::vector<std::thread> &threads)
+	{
+		this->stop_sources(threads);
+
+		{
+			const std::lock_guard<std::mutex> lock {m_lock};
+			m_application->on_reopen();
+		}
+
+		try {
+			// The old file descriptors are closed once nothing refers to them.
+			m_device = std::make_shared<HidrawDevice>(m_path);
+			m_ipts = ipts::Device {m_device};
+			m_ipts.set_mode(ipts::Mode::Multitouch);
+
+			for (std::unique_ptr<Source> &source : m_sources) {
+				const std::filesystem::path path = source->path;
+				const usize index = source->index;
+
+				source = std::make_unique<Source>(path, index);
+				source->ipts.set_mode(ipts::Mode::Multitouch);
+			}
+		} catch (const std::exception &e) {
+			spdlog::error("Failed to open the device again: {}", e.what());
+			return false;
+		}
+
+		m_last_data = chrono::steady_clock::now();
+		this->start_sources(threads);
+
+		return true;
+	}
+
+	/*!
+	 * Starts reading from the additional devices, each in its own thread.
+	 *
+	 * @param[out] threads The threads that read from the additional devices.
+	 */
+	void start_sources(std::vector<std::thread> &threads)
+	{
+		m_running = true;
+
+		for (const std::unique_ptr<Source> &source : m_sources)
+			threads.emplace_back([&, ptr = source.get()] { this->read_source(*ptr); });
+	}
+
+	/*!
+	 * Stops reading from the additional devices, and waits for the threads to finish.
+	 *
+	 * @param[in,out] threads The threads that read from the additional devices.
+	 */
+	void stop_sources(std::vector<std::thread> &threads)
+	{
+		m_running = false;
+
+		for (std::thread &thread : threads)
+			thread.join();
+
+		threads.clear();
+	}
+
+	/*!
+	 * Reads from an additional device until the runner stops.
+	 *
+	 * If the device keeps failing, the runner stops as if it lost the connection.
+	 *
+	 * @param[in] source The device to read from.
+	 */
+	void read_source(Source &source)
+	{
+		usize errors = 0;
+		usize parse_errors = 0;
+
+		while (m_running) {
+			if (errors >= 50) {
+				spdlog::error("Encountered 50 errors on a source, aborting...");
+				m_source_failed = true;
+				break;
+			}
+
+			if (this->desynced(parse_errors)) {
+				spdlog::error("Encountered {} malformed buffers", parse_errors);
+				m_source_failed = true;
+				break;
+			}
+
+			this->read_buffer(*source.device,
+			                  source.ipts,
+			                  source.buffer,
+			                  source.index,
+			                  errors,
+			                  parse_errors);
+		}
+	}
+
+	/*!
+	 * Loads the configuration files again and passes them to the application.
+	 *
+	 * If the new configuration can't be loaded, the application keeps the old one.
+	 */
+	void reload_config()
+	{
+		spdlog::info("Reloading config");
+
+		try {
+			const ConfigLoader loader {m_info, m_metadata};
+			m_application->reload(loader.config());
+			m_parse_error_limit = loader.config().parse_error_limit;
+			m_watchdog = loader.config().watchdog;
+		} catch (const std::exception &e) {
+			spdlog::error("Failed to reload config, keeping the old one: {}", e.what());
+		}
 	}
 };
 
@@ -12,6 +12,11 @@ namespace iptsd::core::linux {
 enum class Error : u8 {
 	ParsingFailed,
 	ParsingTypeNotImplemented,
+	ParsingInvalidSerial,
+	ParsingInvalidKey,
+	ParsingInvalidKeyMapping,
+	ParsingInvalidOrientation,
+	ParsingInvalidTransform,
 	RunnerInitError,
 
 	SyscallOpenFailed,
@@ -20,6 +25,10 @@ enum class Error : u8 {
 	SyscallCloseFailed,
 	SyscallIoctlFailed,
 	SyscallSigactionFailed,
+	SyscallPollFailed,
+	SyscallSocketFailed,
+	SyscallConnectFailed,
+	SyscallSendFailed,
 };
 
 inline std::string format_as(Error err)
@@ -29,6 +38,16 @@ inline std::string format_as(Error err)
 		return "core: linux: Failed to parse INI file {}!";
 	case Error::ParsingTypeNotImplemented:
 		return "core: linux: Parsing not implemented for type {}!";
+	case Error::ParsingInvalidSerial:
+		return "core: linux: Invalid stylus serial number {}!";
+	case Error::ParsingInvalidKey:
+		return "core: linux: Invalid key code {}!";
+	case Error::ParsingInvalidKeyMapping:
+		return "core: linux: Invalid key mapping {}, must be button:<key> or rubber:<key>!";
+	case Error::ParsingInvalidOrientation:
+		return "core: linux: Invalid orientation {}, must be one of 0, 90, 180 or 270!";
+	case Error::ParsingInvalidTransform:
+		return "core: linux: Invalid transform {}, must be six numbers!";
 	case Error::RunnerInitError:
 		return "core: linux: Runner initialization failed!";
 	case Error::SyscallOpenFailed:
@@ -43,6 +62,14 @@ inline std::string format_as(Error err)
 		return "core: linux: IOCTL {} failed: {}";
 	case Error::SyscallSigactionFailed:
 		return "core: linux: Sigaction for signal {} failed: {}";
+	case Error::SyscallPollFailed:
+		return "core: linux: Polling file failed: {}";
+	case Error::SyscallSocketFailed:
+		return "core: linux: Creating socket failed: {}";
+	case Error::SyscallConnectFailed:
+		return "core: linux: Connecting to socket {} failed: {}";
+	case Error::SyscallSendFailed:
+		return "core: linux: Sending to socket failed: {}";
 	default:
 		return "core: linux: Invalid error code!";
 	}
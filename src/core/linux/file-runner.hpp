@@ -10,8 +10,10 @@
 #include <core/generic/application.hpp>
 #include <ipts/data.hpp>
 
+#include <gsl/gsl>
 #include <spdlog/spdlog.h>
 
+#include <algorithm>
 #include <atomic>
 #include <filesystem>
 #include <fstream>
@@ -118,19 +120,28 @@ public:
 				/*
 				 * Abort if there is not enough data left.
 				 */
-				if (local.size() < (sizeof(u64) + m_info.buffer_size))
+				if (local.size() < sizeof(u64))
 					break;
 
-				const auto size = local.read<u64>();
+				// The upper half of the header is the source that sent the data.
+				const auto header = local.read<u64>();
+				const u64 size = header & 0xFFFFFFFF;
+				const usize source = casts::to<usize>(header >> 32);
 
 				/*
 				 * This is an error baked into the format.
 				 * The writer should simply write as many bytes as it just received,
 				 * instead of writing the entire buffer all the time.
 				 */
-				Reader buffer = local.sub(casts::to<usize>(m_info.buffer_size));
+				const u64 padded = std::max(size, m_info.buffer_size);
 
-				m_application->process(buffer.subspan<u8>(casts::to<usize>(size)));
+				if (local.size() < padded)
+					break;
+
+				Reader buffer = local.sub(casts::to<usize>(padded));
+
+				const usize length = casts::to<usize>(size);
+				m_application->process(buffer.subspan<u8>(length), source);
 			} catch (const std::exception &e) {
 				spdlog::warn(e.what());
 			}
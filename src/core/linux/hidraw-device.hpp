@@ -6,6 +6,7 @@
 #include "syscalls.hpp"
 
 #include <common/casts.hpp>
+#include <common/chrono.hpp>
 #include <common/types.hpp>
 #include <hid/device.hpp>
 #include <hid/parser.hpp>
@@ -107,6 +108,21 @@ public:
 		return syscalls::read(m_fd, buffer);
 	}
 
+	/*!
+	 * Waits until a report can be read from the HID device.
+	 *
+	 * @param[in] timeout How long to wait for a report.
+	 * @return true if a report is ready, false if the timeout expired.
+	 */
+	bool wait(const chrono::milliseconds timeout) const
+	{
+		struct pollfd fd {};
+		fd.fd = m_fd;
+		fd.events = POLLIN;
+
+		return syscalls::poll(fd, casts::to<int>(timeout.count())) > 0;
+	}
+
 	/*!
 	 * Gets the data of a HID feature report.
 	 *
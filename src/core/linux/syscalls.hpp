@@ -11,12 +11,17 @@
 #include <gsl/gsl>
 
 #include <linux/input.h>
+#include <poll.h>
 #include <sys/ioctl.h>
+#include <sys/socket.h>
+#include <sys/un.h>
 
 #include <cerrno>
 #include <csignal> // IWYU pragma: keep
+#include <cstddef>
 #include <fcntl.h>
 #include <filesystem>
+#include <string>
 #include <system_error>
 #include <unistd.h>
 
@@ -97,6 +102,20 @@ inline int ioctl(const int fd, const unsigned long rq, T data = nullptr)
 	return ret;
 }
 
+inline int poll(struct pollfd &fd, const int timeout)
+{
+	const int ret = ::poll(&fd, 1, timeout);
+
+	// Being interrupted by a signal is the same as timing out for the caller.
+	if (ret == -1 && errno == EINTR)
+		return 0;
+
+	if (ret == -1)
+		throw common::Error<Error::SyscallPollFailed> {impl::last_error()};
+
+	return ret;
+}
+
 inline int sigaction(const int sig, const struct sigaction *act, struct sigaction *oact = nullptr)
 {
 	const int ret = ::sigaction(sig, act, oact);
@@ -106,6 +125,61 @@ inline int sigaction(const int sig, const struct sigaction *act, struct sigactio
 	return ret;
 }
 
+inline int socket(const int domain, const int type)
+{
+	const int ret = ::socket(domain, type, 0);
+	if (ret == -1)
+		throw common::Error<Error::SyscallSocketFailed> {impl::last_error()};
+
+	return ret;
+}
+
+/*!
+ * Connects a socket to a unix socket.
+ *
+ * @param[in] fd The socket to connect.
+ * @param[in] path The path of the socket, or its name in the abstract namespace if it starts
+ *                 with an @ (like the sockets of Android's LocalServerSocket).
+ */
+inline int connect(const int fd, const std::string &path)
+{
+	struct sockaddr_un addr {};
+	addr.sun_family = AF_UNIX;
+
+	// NOLINTNEXTLINE(cppcoreguidelines-pro-bounds-array-to-pointer-decay)
+	const usize length = path.copy(addr.sun_path, sizeof(addr.sun_path) - 1, 0);
+
+	// Abstract socket names start with a null byte instead.
+	if (!path.empty() && path[0] == '@')
+		addr.sun_path[0] = '\0';
+
+	const usize offset = offsetof(struct sockaddr_un, sun_path);
+	const auto size = gsl::narrow_cast<socklen_t>(offset + length);
+
+	// NOLINTNEXTLINE(cppcoreguidelines-pro-type-reinterpret-cast)
+	const int ret = ::connect(fd, reinterpret_cast<const struct sockaddr *>(&addr), size);
+	if (ret == -1)
+		throw common::Error<Error::SyscallConnectFailed> {path, impl::last_error()};
+
+	return ret;
+}
+
+template <class T>
+inline isize send(const int fd, const gsl::span<T> data, const int flags = 0)
+{
+	// A reader that went away must not kill the daemon with SIGPIPE.
+	const isize ret = ::send(fd, data.data(), data.size_bytes(), flags | MSG_NOSIGNAL);
+
+	// A full socket is the same as sending nothing for the caller.
+	if (ret == -1 && (errno == EAGAIN || errno == EWOULDBLOCK))
+		return 0;
+
+	if (ret == -1)
+		throw common::Error<Error::SyscallSendFailed> {impl::last_error()};
+
+	return ret;
+}
+
 } // namespace iptsd::core::linux::syscalls
 
 #endif // IPTSD_CORE_LINUX_SYSCALLS_HPP
@@ -21,11 +21,21 @@ struct StylusData {
 	bool rubber = false;
 
 	u16 timestamp = 0;
+
+	// Normalized coordinates. Range: [0, 1]
 	f64 x = 0;
 	f64 y = 0;
+
+	// Normalized pressure. Range: [0, 1]
 	f64 pressure = 0;
+
+	// The orientation of the stylus, already converted to radians by the parser.
 	f64 altitude = 0;
 	f64 azimuth = 0;
+
+	// Whether the orientation was reported. An altitude of 0 means the stylus is upright.
+	bool has_tilt = false;
+
 	u32 serial = 0;
 };
 
@@ -13,6 +13,7 @@
 #include "protocol/stylus.hpp"
 
 #include <common/casts.hpp>
+#include <common/error.hpp>
 #include <common/reader.hpp>
 #include <common/types.hpp>
 
@@ -20,10 +21,34 @@
 
 #include <functional>
 #include <optional>
+#include <string>
 
 namespace iptsd::ipts {
+namespace impl {
+
+enum class ParserError : u8 {
+	InvalidFrameSize,
+	FrameTooSmall,
+};
+
+inline std::string format_as(ParserError err)
+{
+	switch (err) {
+	case ParserError::InvalidFrameSize:
+		return "ipts: Frame of type {:#04x} claims {} bytes, but only {} are left!";
+	case ParserError::FrameTooSmall:
+		return "ipts: Frame of type {:#04x} is smaller than its header ({} bytes)!";
+	default:
+		return "ipts: Invalid error code!";
+	}
+}
+
+} // namespace impl
 
 class Parser {
+public:
+	using Error = impl::ParserError;
+
 public:
 	// The callback that is invoked when stylus data was parsed.
 	std::function<void(const StylusData &)> on_stylus;
@@ -37,6 +62,22 @@ public:
 	// The callback that is invoked when a metadata report was parsed.
 	std::function<void(const Metadata &)> on_metadata;
 
+	// The callback that is invoked for every report frame, before it is parsed.
+	std::function<void(u32 type, usize size)> on_report;
+
+	// The callback that is invoked when a frame of an unknown type was skipped.
+	std::function<void(u32 type, usize size)> on_unknown;
+
+	// The logical range of the coordinates that are reported by the stylus.
+	u16 stylus_max_x = protocol::stylus::MAX_X;
+	u16 stylus_max_y = protocol::stylus::MAX_Y;
+
+	// The pressure range of MPP 1.0 styli, which is smaller than the one of newer styli.
+	u16 stylus_max_pressure_mpp_1_0 = protocol::stylus::MAX_PRESSURE_MPP_1_0;
+
+	// If set, all stylus reports are parsed with this format, regardless of their type.
+	std::optional<protocol::report::Type> stylus_format {};
+
 private:
 	protocol::heatmap::Dimensions m_dim {};
 	protocol::dft::Metadata m_dft_meta {};
@@ -75,6 +116,22 @@ private:
 		this->parse_hid_frame(reader);
 	}
 
+	/*!
+	 * Makes sure that a frame doesn't claim more data than what is left in the buffer.
+	 *
+	 * Malformed or truncated frames would otherwise be parsed with data that belongs to
+	 * the following frames, or run past the end of the buffer.
+	 *
+	 * @param[in] reader The data that is following the header of the frame.
+	 * @param[in] type The type of the frame, for error reporting.
+	 * @param[in] size The size of the frame payload, as claimed by its header.
+	 */
+	static void check_frame_size(const Reader &reader, const u32 type, const usize size)
+	{
+		if (size > reader.size())
+			throw common::Error<Error::InvalidFrameSize> {type, size, reader.size()};
+	}
+
 	/*!
 	 * Parses an IPTS HID frame.
 	 *
@@ -86,7 +143,16 @@ private:
 	void parse_hid_frame(Reader &reader)
 	{
 		const auto frame = reader.read<protocol::hid::Frame>();
-		Reader sub = reader.sub(frame.size - sizeof(frame));
+		const auto type = gsl::narrow_cast<u32>(frame.type);
+
+		// The size of HID frames includes the header.
+		if (frame.size < sizeof(frame))
+			throw common::Error<Error::FrameTooSmall> {type, frame.size};
+
+		const usize size = frame.size - sizeof(frame);
+		check_frame_size(reader, type, size);
+
+		Reader sub = reader.sub(size);
 
 		switch (frame.type) {
 		case protocol::hid::FrameType::Hid:
@@ -116,7 +182,9 @@ private:
 			this->parse_report_frames(sub);
 			break;
 		default:
-			// TODO: Add handler for unknown data and wire up debug tools
+			if (this->on_unknown)
+				this->on_unknown(type, size);
+
 			break;
 		}
 	}
@@ -146,6 +214,10 @@ private:
 
 		for (u32 i = 0; i < header.elements; i++) {
 			const auto group = reader.read<protocol::legacy::ReportGroup>();
+			const auto type = gsl::narrow_cast<u32>(group.type);
+
+			check_frame_size(reader, type, group.size);
+
 			Reader sub = reader.sub(group.size);
 
 			switch (group.type) {
@@ -154,7 +226,9 @@ private:
 				this->parse_report_frames(sub);
 				break;
 			default:
-				// TODO: Add handler for unknown data and wire up debug tools
+				if (this->on_unknown)
+					this->on_unknown(type, group.size);
+
 				break;
 			}
 		}
@@ -192,9 +266,16 @@ private:
 	void parse_report_frame(Reader &reader)
 	{
 		const auto frame = reader.read<protocol::report::Frame>();
+		const auto type = gsl::narrow_cast<u32>(frame.type);
+
+		check_frame_size(reader, type, frame.size);
+
+		if (this->on_report)
+			this->on_report(type, frame.size);
+
 		Reader sub = reader.sub(frame.size);
 
-		switch (frame.type) {
+		switch (this->report_type(frame.type)) {
 		case protocol::report::Type::StylusMPP_1_0:
 			this->parse_stylus_mpp_1_0(sub);
 			break;
@@ -214,11 +295,33 @@ private:
 			this->parse_dft_window(sub);
 			break;
 		default:
-			// TODO: Add handler for unknown data and wire up debug tools
+			if (this->on_unknown)
+				this->on_unknown(type, frame.size);
+
 			break;
 		}
 	}
 
+	/*!
+	 * Applies the configured stylus format to the type of a report frame.
+	 *
+	 * @param[in] type The type of the report frame.
+	 * @return The type that the report should be parsed as.
+	 */
+	[[nodiscard]] protocol::report::Type report_type(const protocol::report::Type type) const
+	{
+		if (!this->stylus_format.has_value())
+			return type;
+
+		switch (type) {
+		case protocol::report::Type::StylusMPP_1_0:
+		case protocol::report::Type::StylusMPP_1_51:
+			return this->stylus_format.value();
+		default:
+			return type;
+		}
+	}
+
 	/*!
 	 * Parses a list of IPTS report frames.
 	 *
@@ -267,9 +370,9 @@ private:
 		data.y = casts::to<f64>(sample.y);
 		data.pressure = casts::to<f64>(sample.pressure);
 
-		data.x /= protocol::stylus::MAX_X;
-		data.y /= protocol::stylus::MAX_Y;
-		data.pressure /= protocol::stylus::MAX_PRESSURE_MPP_1_0;
+		data.x /= this->stylus_max_x;
+		data.y /= this->stylus_max_y;
+		data.pressure /= this->stylus_max_pressure_mpp_1_0;
 
 		data.altitude = 0;
 		data.azimuth = 0;
@@ -316,8 +419,8 @@ private:
 		data.y = casts::to<f64>(sample.y);
 		data.pressure = casts::to<f64>(sample.pressure);
 
-		data.x /= protocol::stylus::MAX_X;
-		data.y /= protocol::stylus::MAX_Y;
+		data.x /= this->stylus_max_x;
+		data.y /= this->stylus_max_y;
 		data.pressure /= protocol::stylus::MAX_PRESSURE_MPP_1_51;
 
 		data.altitude = casts::to<f64>(sample.altitude);
@@ -325,6 +428,7 @@ private:
 
 		data.altitude /= 18000.0 / M_PI;
 		data.azimuth /= 18000.0 / M_PI;
+		data.has_tilt = true;
 
 		this->on_stylus(data);
 	}
@@ -384,6 +488,9 @@ private:
 	void parse_heatmap_frame(Reader &reader) const
 	{
 		const auto header = reader.read<protocol::heatmap::Frame>();
+		const auto type = gsl::narrow_cast<u32>(protocol::hid::FrameType::Heatmap);
+		check_frame_size(reader, type, header.size);
+
 		Reader sub = reader.sub(header.size);
 
 		this->parse_heatmap_data(sub);
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_TESTS_BUFFERS_HPP
+#define IPTSD_TESTS_BUFFERS_HPP
+
+#include <common/casts.hpp>
+#include <common/types.hpp>
+#include <ipts/protocol/hid.hpp>
+#include <ipts/protocol/report.hpp>
+#include <ipts/protocol/stylus.hpp>
+
+#include <vector>
+
+namespace iptsd::tests {
+
+/*!
+ * Appends the bytes of a protocol structure to a buffer.
+ *
+ * @param[in,out] buffer The buffer to extend.
+ * @param[in] value The structure, in the exact layout that the device sends.
+ */
+template <class T>
+void append(std::vector<u8> &buffer, const T &value)
+{
+	const auto *bytes = reinterpret_cast<const u8 *>(&value);
+	buffer.insert(buffer.end(), bytes, bytes + sizeof(T));
+}
+
+/*!
+ * Creates a report frame, which is the header of a report followed by its payload.
+ *
+ * @param[in] type The type of the report frame.
+ * @param[in] payload The contents of the report.
+ * @return The bytes of the report frame.
+ */
+inline std::vector<u8> report_frame(const ipts::protocol::report::Type type,
+                                    const std::vector<u8> &payload)
+{
+	namespace report = ipts::protocol::report;
+
+	report::Frame frame {};
+	frame.type = type;
+	frame.size = casts::to<u16>(payload.size());
+
+	std::vector<u8> buffer {};
+	append(buffer, frame);
+	buffer.insert(buffer.end(), payload.begin(), payload.end());
+
+	return buffer;
+}
+
+/*!
+ * Wraps report frames into the frames that the device sends over HID.
+ *
+ * The layout is: HID report header, HID frame of type Reports, followed by the report frames.
+ *
+ * @param[in] reports The report frames, one after another.
+ * @return The buffer, as it would be read from the hidraw device.
+ */
+inline std::vector<u8> reports_buffer(const std::vector<u8> &reports)
+{
+	namespace hid = ipts::protocol::hid;
+
+	hid::ReportHeader header {};
+	header.id = 0x40;
+
+	hid::Frame frame {};
+	frame.size = casts::to<u32>(sizeof(hid::Frame) + reports.size());
+	frame.type = hid::FrameType::Reports;
+
+	std::vector<u8> buffer {};
+	append(buffer, header);
+	append(buffer, frame);
+	buffer.insert(buffer.end(), reports.begin(), reports.end());
+
+	return buffer;
+}
+
+/*!
+ * Creates the report frame of a stylus, which is the stylus report header followed by the
+ * samples of the stylus.
+ *
+ * @param[in] type The type of the report frame, which selects the format of the samples.
+ * @param[in] serial The serial number of the stylus.
+ * @param[in] samples The samples of the stylus.
+ * @return The bytes of the report frame.
+ */
+template <class Sample>
+std::vector<u8> stylus_report(const ipts::protocol::report::Type type,
+                              const u32 serial,
+                              const std::vector<Sample> &samples)
+{
+	namespace stylus = ipts::protocol::stylus;
+
+	stylus::Report report {};
+	report.samples = casts::to<u8>(samples.size());
+	report.serial = serial;
+
+	std::vector<u8> payload {};
+	append(payload, report);
+
+	for (const Sample &sample : samples)
+		append(payload, sample);
+
+	return report_frame(type, payload);
+}
+
+/*!
+ * Wraps a stylus report into the frames that the device sends over HID.
+ *
+ * The layout is: HID report header, HID frame of type Reports, report frame, stylus report
+ * header, followed by the samples of the stylus.
+ *
+ * @param[in] type The type of the report frame, which selects the format of the samples.
+ * @param[in] serial The serial number of the stylus.
+ * @param[in] samples The samples of the stylus.
+ * @return The buffer, as it would be read from the hidraw device.
+ */
+template <class Sample>
+std::vector<u8> stylus_buffer(const ipts::protocol::report::Type type,
+                              const u32 serial,
+                              const std::vector<Sample> &samples)
+{
+	return reports_buffer(stylus_report(type, serial, samples));
+}
+
+} // namespace iptsd::tests
+
+#endif // IPTSD_TESTS_BUFFERS_HPP
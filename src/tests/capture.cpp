@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#include "test.hpp"
+
+#include <apps/daemon/capture.hpp>
+#include <common/casts.hpp>
+#include <common/reader.hpp>
+#include <common/types.hpp>
+#include <core/generic/device.hpp>
+
+#include <gsl/gsl>
+
+#include <cstdlib>
+#include <filesystem>
+#include <fstream>
+#include <iterator>
+#include <optional>
+#include <string>
+#include <vector>
+
+namespace iptsd::tests {
+namespace {
+
+using apps::daemon::Capture;
+
+/*
+ * A directory for the capture files of one test, which is removed again afterwards.
+ */
+class Directory {
+public:
+	std::filesystem::path path;
+
+public:
+	Directory()
+	{
+		const std::filesystem::path temp = std::filesystem::temp_directory_path();
+		std::string name = (temp / "iptsd-XXXXXX").string();
+
+		expect(::mkdtemp(name.data()) != nullptr, "failed to create a directory");
+		path = name;
+	}
+
+	~Directory()
+	{
+		std::filesystem::remove_all(path);
+	}
+
+	Directory(const Directory &) = delete;
+	Directory &operator=(const Directory &) = delete;
+};
+
+/*!
+ * The information about a device with 16 byte buffers.
+ */
+core::DeviceInfo device()
+{
+	core::DeviceInfo info {};
+	info.vendor = 0x045E;
+	info.product = 0x0C1A;
+	info.buffer_size = 16;
+
+	return info;
+}
+
+/*!
+ * Reads all bytes of a file.
+ *
+ * @param[in] path The file to read.
+ * @return The contents of the file.
+ */
+std::vector<u8> contents(const std::filesystem::path &path)
+{
+	std::ifstream ifs {path, std::ios::in | std::ios::binary};
+	std::noskipws(ifs);
+
+	return std::vector<u8> {std::istream_iterator<u8>(ifs), std::istream_iterator<u8>()};
+}
+
+void records()
+{
+	const Directory dir {};
+	const std::filesystem::path file = dir.path / "capture.bin";
+
+	std::vector<u8> small(8, 0xAA);
+	std::vector<u8> large(32, 0xBB);
+
+	{
+		Capture capture {file, 0, device(), std::nullopt};
+
+		capture.write(gsl::span<u8> {small}, 0);
+		capture.write(gsl::span<u8> {large}, 2);
+	}
+
+	std::vector<u8> data = contents(file);
+	Reader reader {gsl::span<u8> {data}};
+
+	const auto info = reader.read<core::DeviceInfo>();
+	expect_eq(info.buffer_size, u64 {16}, "buffer size in the header");
+	expect_eq(reader.read<u8>(), u8 {0}, "metadata flag");
+
+	// A buffer of the main device is padded to the buffer size.
+	expect_eq(reader.read<u64>(), u64 {8}, "header of the main device");
+	expect_eq(reader.subspan<u8>(16)[8], u8 {0}, "padding of the main device");
+
+	// A larger buffer from a source is stored in full, and tagged with the source.
+	expect_eq(reader.read<u64>(), u64 {32} | (u64 {2} << 32), "header of the source");
+	expect_eq(reader.subspan<u8>(32)[31], u8 {0xBB}, "end of the source buffer");
+
+	expect_eq(reader.size(), usize {0}, "size of the leftover data");
+}
+
+void rotation()
+{
+	const Directory dir {};
+	const std::filesystem::path file = dir.path / "capture.bin";
+
+	// Every file only fits a single record.
+	const usize limit = sizeof(core::DeviceInfo) + 1 + sizeof(u64) + 16;
+
+	{
+		Capture capture {file, limit, device(), std::nullopt};
+
+		for (usize i = 0; i < 8; i++) {
+			std::vector<u8> data(16, casts::to<u8>(i));
+			capture.write(gsl::span<u8> {data}, 0);
+		}
+	}
+
+	const auto last = [&](const std::filesystem::path &path) {
+		const std::vector<u8> data = contents(path);
+		return data.empty() ? u8 {0xFF} : data.back();
+	};
+
+	// A new capture file is started and rotated whenever the next record doesn't fit.
+	expect_eq(last(file), u8 {7}, "content of the current file");
+
+	for (usize i = 1; i <= apps::daemon::BACKUPS; i++) {
+		std::filesystem::path backup = file;
+		backup += "." + std::to_string(i);
+
+		expect(std::filesystem::exists(backup), backup.string() + " was not kept");
+		expect_eq(last(backup), casts::to<u8>(7 - i), "content of " + backup.string());
+	}
+
+	std::filesystem::path oldest = file;
+	oldest += "." + std::to_string(apps::daemon::BACKUPS + 1);
+
+	expect(!std::filesystem::exists(oldest), "more backups were kept than configured");
+}
+
+} // namespace
+} // namespace iptsd::tests
+
+int main()
+{
+	using namespace iptsd::tests;
+
+	return run({
+		{"records", records},
+		{"rotation", rotation},
+	});
+}
@@ -0,0 +1,419 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#include "recording-emitter.hpp"
+#include "test.hpp"
+
+#include <apps/daemon/stylus.hpp>
+#include <apps/daemon/touch.hpp>
+#include <common/types.hpp>
+#include <contacts/contact.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+#include <ipts/data.hpp>
+
+#include <linux/input-event-codes.h>
+
+#include <chrono>
+#include <memory>
+#include <thread>
+#include <vector>
+
+namespace iptsd::tests {
+namespace {
+
+using apps::daemon::StylusDevice;
+using apps::daemon::TouchDevice;
+
+/*!
+ * The configuration of a 26 x 17 cm screen, with everything else left at the defaults.
+ */
+core::Config screen()
+{
+	core::Config config {};
+	config.width = 26;
+	config.height = 17;
+
+	return config;
+}
+
+/*!
+ * A stylus that touches the display, without any tilt.
+ */
+ipts::StylusData touching()
+{
+	ipts::StylusData data {};
+	data.proximity = true;
+	data.contact = true;
+	data.timestamp = 100;
+	data.x = 0.5;
+	data.y = 0.25;
+	data.pressure = 0.5;
+	data.serial = 1;
+
+	return data;
+}
+
+/*!
+ * A finger in the middle of the display.
+ */
+contacts::Contact<f64> finger()
+{
+	contacts::Contact<f64> contact {};
+	contact.mean = Vector2<f64> {0.5, 0.5};
+	contact.size = Vector2<f64> {0.1, 0.05};
+	contact.orientation = 0.25;
+	contact.normalized = true;
+	contact.index = 0;
+	contact.valid = true;
+	contact.stable = true;
+
+	return contact;
+}
+
+void stylus_sample()
+{
+	const auto emitter = std::make_shared<RecordingEmitter>();
+	StylusDevice stylus {screen(), core::DeviceInfo {}, emitter};
+
+	expect(emitter->created, "stylus device was not created");
+	expect(emitter->keys.count(BTN_STYLUS) == 1, "BTN_STYLUS is not registered");
+	expect(emitter->keys.count(BTN_TOOL_RUBBER) == 1, "BTN_TOOL_RUBBER is not registered");
+
+	stylus.update(touching());
+
+	const std::vector<Event> expected {
+		{EV_KEY, BTN_TOUCH, 1},
+		{EV_KEY, BTN_TOOL_PEN, 1},
+		{EV_KEY, BTN_TOOL_RUBBER, 0},
+		{EV_KEY, BTN_STYLUS, 0},
+		{EV_ABS, ABS_X, 4800},
+		{EV_ABS, ABS_Y, 1800},
+		{EV_ABS, ABS_PRESSURE, 2048},
+		{EV_ABS, ABS_DISTANCE, 0},
+		{EV_ABS, ABS_MISC, 100},
+		{EV_SYN, SYN_REPORT, 0},
+	};
+
+	expect_events(emitter->events, expected, "events of a stylus sample");
+}
+
+void stylus_leaves()
+{
+	const auto emitter = std::make_shared<RecordingEmitter>();
+	StylusDevice stylus {screen(), core::DeviceInfo {}, emitter};
+
+	stylus.update(touching());
+	emitter->clear();
+
+	ipts::StylusData data = touching();
+	data.proximity = false;
+	data.contact = false;
+
+	stylus.update(data);
+
+	const std::vector<Event> expected {
+		{EV_KEY, BTN_TOUCH, 0},
+		{EV_KEY, BTN_TOOL_PEN, 0},
+		{EV_KEY, BTN_TOOL_RUBBER, 0},
+		{EV_KEY, BTN_STYLUS, 0},
+		{EV_SYN, SYN_REPORT, 0},
+	};
+
+	expect_events(emitter->events, expected, "events of a stylus leaving proximity");
+	expect(!stylus.active(), "stylus is still active");
+}
+
+void released_on_shutdown()
+{
+	const auto stylus_emitter = std::make_shared<RecordingEmitter>();
+	StylusDevice stylus {screen(), core::DeviceInfo {}, stylus_emitter};
+
+	const auto touch_emitter = std::make_shared<RecordingEmitter>();
+	TouchDevice touch {screen(), core::DeviceInfo {}, touch_emitter};
+
+	ipts::StylusData data = touching();
+	data.button = true;
+
+	stylus.update(data);
+	touch.update({finger()});
+
+	stylus_emitter->clear();
+	touch_emitter->clear();
+
+	// This is what the daemon does when it stops, before the devices are removed.
+	stylus.disable();
+	touch.disable();
+
+	const std::vector<Event> pen {
+		{EV_KEY, BTN_TOUCH, 0},
+		{EV_KEY, BTN_TOOL_PEN, 0},
+		{EV_KEY, BTN_TOOL_RUBBER, 0},
+		{EV_KEY, BTN_STYLUS, 0},
+		{EV_SYN, SYN_REPORT, 0},
+	};
+
+	const std::vector<Event> fingers {
+		{EV_ABS, ABS_MT_SLOT, 0},
+		{EV_ABS, ABS_MT_TRACKING_ID, -1},
+		{EV_KEY, BTN_TOUCH, 0},
+		{EV_SYN, SYN_REPORT, 0},
+	};
+
+	expect_events(stylus_emitter->events, pen, "events of the stylus on shutdown");
+	expect_events(touch_emitter->events, fingers, "events of the touchscreen on shutdown");
+}
+
+void released_on_takeover()
+{
+	const auto emitter = std::make_shared<RecordingEmitter>();
+	StylusDevice stylus {screen(), core::DeviceInfo {}, emitter};
+
+	ipts::StylusData data = touching();
+	data.button = true;
+
+	stylus.update(data);
+	emitter->clear();
+
+	ipts::StylusData other = touching();
+	other.serial = 2;
+
+	// The previous pen is released, before the new one touches the display.
+	stylus.update(other);
+
+	const std::vector<Event> lifted {
+		{EV_KEY, BTN_TOUCH, 0},
+		{EV_KEY, BTN_TOOL_PEN, 0},
+		{EV_KEY, BTN_TOOL_RUBBER, 0},
+		{EV_KEY, BTN_STYLUS, 0},
+		{EV_SYN, SYN_REPORT, 0},
+		{EV_KEY, BTN_TOUCH, 1},
+	};
+
+	const std::vector<Event> first {emitter->events.begin(), emitter->events.begin() + 6};
+	expect_events(first, lifted, "events of a stylus taking over");
+}
+
+void warmup_after_timeout()
+{
+	core::Config config = screen();
+	config.stylus_warmup_reports = 1;
+	config.stylus_timeout = 1;
+
+	const auto emitter = std::make_shared<RecordingEmitter>();
+	StylusDevice stylus {config, core::DeviceInfo {}, emitter};
+
+	stylus.update(touching());
+	stylus.update(touching());
+	expect(stylus.active(), "stylus is not active after the warm-up");
+
+	std::this_thread::sleep_for(std::chrono::milliseconds {5});
+	stylus.check_timeout();
+	expect(!stylus.active(), "stylus is still active after the timeout");
+
+	// The stylus returns without ever reporting that it left proximity.
+	emitter->clear();
+	stylus.update(touching());
+
+	expect(emitter->events.empty(), "the first report after the timeout was not discarded");
+	expect(!stylus.active(), "stylus is active while warming up");
+
+	stylus.update(touching());
+	expect(stylus.active(), "stylus is not active after the warm-up");
+}
+
+void rubber_approaches()
+{
+	core::Config config = screen();
+	config.stylus_rubber_debounce = 2;
+
+	const auto emitter = std::make_shared<RecordingEmitter>();
+	StylusDevice stylus {config, core::DeviceInfo {}, emitter};
+
+	ipts::StylusData data = touching();
+	data.rubber = true;
+
+	// The tool of the first report is used right away.
+	stylus.update(data);
+
+	const std::vector<Event> tools {
+		{EV_KEY, BTN_TOUCH, 1},
+		{EV_KEY, BTN_TOOL_PEN, 0},
+		{EV_KEY, BTN_TOOL_RUBBER, 1},
+	};
+
+	const std::vector<Event> first {emitter->events.begin(), emitter->events.begin() + 3};
+
+	expect_events(first, tools, "tools of an approaching eraser");
+	expect(stylus.rubber(), "eraser is reported as a pen");
+
+	// Later changes are still debounced.
+	data.rubber = false;
+	stylus.update(data);
+
+	expect(stylus.rubber(), "a single report switched the tool");
+}
+
+void rubber_released()
+{
+	const auto emitter = std::make_shared<RecordingEmitter>();
+	StylusDevice stylus {screen(), core::DeviceInfo {}, emitter};
+
+	ipts::StylusData data = touching();
+	data.rubber = true;
+
+	stylus.update(data);
+	emitter->clear();
+
+	// Releasing the tool button in the middle of a stroke ends the stroke of the eraser.
+	data.rubber = false;
+	stylus.update(data);
+
+	const std::vector<Event> lifted {
+		{EV_KEY, BTN_TOUCH, 0},
+		{EV_KEY, BTN_TOOL_PEN, 0},
+		{EV_KEY, BTN_TOOL_RUBBER, 0},
+		{EV_KEY, BTN_STYLUS, 0},
+		{EV_SYN, SYN_REPORT, 0},
+	};
+
+	expect_events(emitter->events, lifted, "events of the released eraser");
+	emitter->clear();
+
+	// Only then the pen touches the display.
+	stylus.update(data);
+
+	const std::vector<Event> pen {
+		{EV_KEY, BTN_TOUCH, 1},
+		{EV_KEY, BTN_TOOL_PEN, 1},
+		{EV_KEY, BTN_TOOL_RUBBER, 0},
+	};
+
+	const std::vector<Event> first {emitter->events.begin(), emitter->events.begin() + 3};
+	expect_events(first, pen, "tools after the eraser was released");
+}
+
+void smoothing_per_stylus()
+{
+	core::Config config = screen();
+	config.stylus_smoothing = 0.9;
+
+	const auto emitter = std::make_shared<RecordingEmitter>();
+	StylusDevice stylus {config, core::DeviceInfo {}, emitter};
+
+	for (usize i = 0; i < 10; i++)
+		stylus.update(touching());
+
+	emitter->clear();
+
+	ipts::StylusData other = touching();
+	other.serial = 2;
+	other.x = 0.1;
+
+	// The new pen must not be blended with the last position of the previous one.
+	stylus.update(other);
+
+	bool found = false;
+
+	for (const Event &event : emitter->events) {
+		if (event.type != EV_ABS || event.code != ABS_X)
+			continue;
+
+		expect_eq(event.value, i32 {960}, "first position of the new stylus");
+		found = true;
+	}
+
+	expect(found, "the new stylus was not emitted");
+}
+
+void prediction_from_timestamps()
+{
+	core::Config config = screen();
+	config.stylus_prediction = 10;
+	config.stylus_timestamp_unit = 100;
+
+	const auto emitter = std::make_shared<RecordingEmitter>();
+	StylusDevice stylus {config, core::DeviceInfo {}, emitter};
+
+	stylus.update(touching());
+	emitter->clear();
+
+	// Both reports are processed at once, but they were measured 10 ms apart.
+	ipts::StylusData data = touching();
+	data.timestamp = 200;
+	data.x = 0.51;
+
+	stylus.update(data);
+
+	bool found = false;
+
+	for (const Event &event : emitter->events) {
+		if (event.type != EV_ABS || event.code != ABS_X)
+			continue;
+
+		expect_eq(event.value, i32 {4992}, "predicted position");
+		found = true;
+	}
+
+	expect(found, "the stylus was not emitted");
+}
+
+void touch_frame()
+{
+	const auto emitter = std::make_shared<RecordingEmitter>();
+	TouchDevice touch {screen(), core::DeviceInfo {}, emitter};
+
+	expect(emitter->created, "touch device was not created");
+	expect(emitter->axes.count(ABS_MT_POSITION_X) == 1, "ABS_MT_POSITION_X is not registered");
+
+	touch.update({finger()});
+
+	const std::vector<Event> down {
+		{EV_ABS, ABS_MT_SLOT, 0},
+		{EV_ABS, ABS_MT_TRACKING_ID, 0},
+		{EV_ABS, ABS_MT_POSITION_X, 4800},
+		{EV_ABS, ABS_MT_POSITION_Y, 3600},
+		{EV_ABS, ABS_MT_ORIENTATION, 45},
+		{EV_ABS, ABS_MT_TOUCH_MAJOR, 1200},
+		{EV_ABS, ABS_MT_TOUCH_MINOR, 600},
+		{EV_KEY, BTN_TOUCH, 1},
+		{EV_ABS, ABS_X, 4800},
+		{EV_ABS, ABS_Y, 3600},
+		{EV_SYN, SYN_REPORT, 0},
+	};
+
+	expect_events(emitter->events, down, "events of a touch frame");
+	emitter->clear();
+
+	touch.update({});
+
+	const std::vector<Event> up {
+		{EV_ABS, ABS_MT_SLOT, 0},
+		{EV_ABS, ABS_MT_TRACKING_ID, -1},
+		{EV_KEY, BTN_TOUCH, 0},
+		{EV_SYN, SYN_REPORT, 0},
+	};
+
+	expect_events(emitter->events, up, "events of a lifted touch frame");
+	expect(!touch.active(), "touchscreen is still active");
+}
+
+} // namespace
+} // namespace iptsd::tests
+
+int main()
+{
+	using namespace iptsd::tests;
+
+	return run({
+		{"stylus_sample", stylus_sample},
+		{"stylus_leaves", stylus_leaves},
+		{"released_on_shutdown", released_on_shutdown},
+		{"released_on_takeover", released_on_takeover},
+		{"warmup_after_timeout", warmup_after_timeout},
+		{"rubber_approaches", rubber_approaches},
+		{"rubber_released", rubber_released},
+		{"smoothing_per_stylus", smoothing_per_stylus},
+		{"prediction_from_timestamps", prediction_from_timestamps},
+		{"touch_frame", touch_frame},
+	});
+}
@@ -0,0 +1,269 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#include "buffers.hpp"
+#include "test.hpp"
+
+#include <common/types.hpp>
+#include <ipts/data.hpp>
+#include <ipts/parser.hpp>
+#include <ipts/protocol/heatmap.hpp>
+#include <ipts/protocol/report.hpp>
+#include <ipts/protocol/stylus.hpp>
+
+#include <gsl/gsl>
+
+#include <cmath>
+#include <exception>
+#include <optional>
+#include <vector>
+
+namespace iptsd::tests {
+namespace {
+
+namespace report = ipts::protocol::report;
+namespace stylus = ipts::protocol::stylus;
+
+/*!
+ * Parses a buffer and returns the stylus data that was found in it.
+ *
+ * @param[in] buffer The data to parse.
+ * @return The last stylus report in the buffer, if any.
+ */
+std::optional<ipts::StylusData> parse_stylus(std::vector<u8> &buffer)
+{
+	std::optional<ipts::StylusData> result = std::nullopt;
+
+	ipts::Parser parser {};
+	parser.on_stylus = [&](const ipts::StylusData &data) { result = data; };
+	parser.parse(gsl::span<u8> {buffer});
+
+	return result;
+}
+
+void stylus_mpp_1_0()
+{
+	stylus::SampleMPP_1_0 sample {};
+	sample.state.proximity = true;
+	sample.state.rubber = true;
+	sample.x = 4800;
+	sample.y = 1800;
+	sample.pressure = 512;
+
+	auto buffer = stylus_buffer(report::Type::StylusMPP_1_0, 0x1234, std::vector {sample});
+	const auto data = parse_stylus(buffer);
+
+	expect(data.has_value(), "no stylus data was parsed");
+	expect_eq(data->serial, u32 {0x1234}, "serial");
+	expect(data->proximity, "proximity is not set");
+	expect(data->contact, "contact is not derived from the pressure");
+	expect(data->rubber, "rubber is not set");
+	expect(!data->button, "button is set");
+	expect(!data->has_tilt, "MPP 1.0 styli have no tilt");
+	expect_eq(data->x, 0.5, "x");
+	expect_eq(data->y, 0.25, "y");
+	expect_eq(data->pressure, 0.5, "pressure");
+}
+
+void stylus_mpp_1_51()
+{
+	stylus::SampleMPP_1_51 sample {};
+	sample.timestamp = 7;
+	sample.state.proximity = true;
+	sample.state.button = true;
+	sample.x = 2400;
+	sample.y = 3600;
+	sample.pressure = 1024;
+	sample.altitude = 4500;
+	sample.azimuth = 9000;
+
+	auto buffer = stylus_buffer(report::Type::StylusMPP_1_51, 0x5678, std::vector {sample});
+	const auto data = parse_stylus(buffer);
+
+	expect(data.has_value(), "no stylus data was parsed");
+	expect_eq(data->serial, u32 {0x5678}, "serial");
+	expect_eq(data->timestamp, u16 {7}, "timestamp");
+	expect(data->button, "button is not set");
+	expect(!data->rubber, "rubber is set");
+	expect(data->has_tilt, "MPP 1.51 styli have tilt");
+	expect_eq(data->x, 0.25, "x");
+	expect_eq(data->y, 0.5, "y");
+	expect_eq(data->pressure, 0.25, "pressure");
+
+	// The orientation is converted from centidegrees to radians.
+	expect(std::abs(data->altitude - (M_PI / 4)) < 1e-9, "altitude is not 45 degrees");
+	expect(std::abs(data->azimuth - (M_PI / 2)) < 1e-9, "azimuth is not 90 degrees");
+}
+
+void stylus_samples()
+{
+	stylus::SampleMPP_1_51 first {};
+	first.state.proximity = true;
+	first.x = 100;
+
+	stylus::SampleMPP_1_51 last {};
+	last.state.proximity = true;
+	last.x = 9600;
+
+	// Only the last sample of a report is processed.
+	auto buffer = stylus_buffer(report::Type::StylusMPP_1_51, 1, std::vector {first, last});
+	const auto data = parse_stylus(buffer);
+
+	expect(data.has_value(), "no stylus data was parsed");
+	expect_eq(data->x, 1.0, "x of the last sample");
+}
+
+void stylus_no_tilt()
+{
+	stylus::SampleMPP_1_0 first {};
+	first.state.proximity = true;
+	first.x = 100;
+
+	stylus::SampleMPP_1_0 last {};
+	last.state.proximity = true;
+	last.x = 2400;
+	last.y = 5400;
+	last.pressure = 1024;
+
+	/*
+	 * No-tilt reports share the 8 byte header of the other stylus reports, which includes
+	 * the serial. Reading a different header would misalign every sample after it.
+	 */
+	const usize header = sizeof(stylus::Report);
+	expect_eq(header, usize {8}, "size of the stylus report header");
+
+	auto buffer = stylus_buffer(report::Type::StylusMPP_1_0, 0xABCD, std::vector {first, last});
+	const auto data = parse_stylus(buffer);
+
+	expect(data.has_value(), "no stylus data was parsed");
+	expect_eq(data->serial, u32 {0xABCD}, "serial");
+	expect(!data->has_tilt, "a no-tilt report has tilt");
+	expect_eq(data->x, 0.25, "x of the last sample");
+	expect_eq(data->y, 0.75, "y of the last sample");
+	expect_eq(data->pressure, 1.0, "pressure of the last sample");
+}
+
+void truncated_frame()
+{
+	stylus::SampleMPP_1_51 sample {};
+	sample.state.proximity = true;
+
+	auto buffer = stylus_buffer(report::Type::StylusMPP_1_51, 1, std::vector {sample});
+	buffer.resize(buffer.size() - 4);
+
+	bool thrown = false;
+
+	try {
+		parse_stylus(buffer);
+	} catch (const std::exception & /* unused */) {
+		thrown = true;
+	}
+
+	expect(thrown, "a frame that claims more data than the buffer holds was parsed");
+}
+
+void zero_size_report()
+{
+	stylus::SampleMPP_1_51 sample {};
+	sample.state.proximity = true;
+	sample.x = 4800;
+
+	// Some firmware pads its frames with empty reports, between and after the real ones.
+	std::vector<u8> reports = report_frame(report::Type::HeatmapTimestamp, {});
+
+	const auto pen = stylus_report(report::Type::StylusMPP_1_51, 1, std::vector {sample});
+	reports.insert(reports.end(), pen.begin(), pen.end());
+
+	const std::vector<u8> padding = report_frame(report::Type::HeatmapTimestamp, {});
+	reports.insert(reports.end(), padding.begin(), padding.end());
+
+	auto buffer = reports_buffer(reports);
+
+	usize count = 0;
+	std::optional<ipts::StylusData> data = std::nullopt;
+
+	ipts::Parser parser {};
+	parser.on_report = [&](u32 /* unused */, usize /* unused */) { count++; };
+	parser.on_stylus = [&](const ipts::StylusData &result) { data = result; };
+	parser.parse(gsl::span<u8> {buffer});
+
+	// Every report advances the parser by at least its header, so none is read twice.
+	expect_eq(count, usize {3}, "number of parsed reports");
+	expect(data.has_value(), "the report after the empty one was not parsed");
+	expect_eq(data->x, 0.5, "x");
+}
+
+void mixed_frame()
+{
+	namespace heatmap = ipts::protocol::heatmap;
+
+	heatmap::Dimensions dim {};
+	dim.rows = 2;
+	dim.columns = 3;
+	dim.y_max = 1;
+	dim.x_max = 2;
+	dim.z_max = 255;
+
+	std::vector<u8> payload {};
+	append(payload, dim);
+
+	stylus::SampleMPP_1_51 sample {};
+	sample.state.proximity = true;
+	sample.x = 2400;
+
+	// Touch and stylus reports are batched into the same frame, in any order.
+	std::vector<u8> reports = report_frame(report::Type::HeatmapDimensions, payload);
+
+	const auto pen = stylus_report(report::Type::StylusMPP_1_51, 1, std::vector {sample});
+	reports.insert(reports.end(), pen.begin(), pen.end());
+
+	const auto touch = report_frame(report::Type::HeatmapData, {1, 2, 3, 4, 5, 6});
+	reports.insert(reports.end(), touch.begin(), touch.end());
+
+	auto buffer = reports_buffer(reports);
+
+	std::vector<char> order {};
+	std::optional<ipts::Heatmap> map = std::nullopt;
+	std::optional<ipts::StylusData> data = std::nullopt;
+
+	ipts::Parser parser {};
+
+	parser.on_heatmap = [&](const ipts::Heatmap &result) {
+		order.push_back('h');
+		map = result;
+	};
+
+	parser.on_stylus = [&](const ipts::StylusData &result) {
+		order.push_back('s');
+		data = result;
+	};
+
+	parser.parse(gsl::span<u8> {buffer});
+
+	expect(data.has_value(), "the stylus report was not parsed");
+	expect(map.has_value(), "the heatmap report was not parsed");
+	expect(order == std::vector {'s', 'h'}, "the reports were not parsed in order");
+
+	expect_eq(data->x, 0.25, "x of the stylus");
+	expect_eq(map->rows, u8 {2}, "rows of the heatmap");
+	expect_eq(map->columns, u8 {3}, "columns of the heatmap");
+	expect_eq(map->data.size(), usize {6}, "size of the heatmap");
+	expect_eq(map->data[5], u8 {6}, "last pixel of the heatmap");
+}
+
+} // namespace
+} // namespace iptsd::tests
+
+int main()
+{
+	using namespace iptsd::tests;
+
+	return run({
+		{"stylus_mpp_1_0", stylus_mpp_1_0},
+		{"stylus_mpp_1_51", stylus_mpp_1_51},
+		{"stylus_samples", stylus_samples},
+		{"stylus_no_tilt", stylus_no_tilt},
+		{"truncated_frame", truncated_frame},
+		{"zero_size_report", zero_size_report},
+		{"mixed_frame", mixed_frame},
+	});
+}
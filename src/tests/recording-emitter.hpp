@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_TESTS_RECORDING_EMITTER_HPP
+#define IPTSD_TESTS_RECORDING_EMITTER_HPP
+
+#include <apps/daemon/emitter.hpp>
+#include <common/types.hpp>
+
+#include <fmt/format.h>
+
+#include <set>
+#include <stdexcept>
+#include <string>
+#include <utility>
+#include <vector>
+
+namespace iptsd::tests {
+
+/*
+ * An input event, as it was passed to an emitter.
+ */
+struct Event {
+	u16 type = 0;
+	u16 code = 0;
+	i32 value = 0;
+
+	bool operator==(const Event &other) const
+	{
+		return type == other.type && code == other.code && value == other.value;
+	}
+
+	bool operator!=(const Event &other) const
+	{
+		return !(*this == other);
+	}
+};
+
+inline std::string format_as(const Event &event)
+{
+	return fmt::format("({}, {}, {})", event.type, event.code, event.value);
+}
+
+/*
+ * Records the capabilities and events of a device, instead of passing them to the kernel.
+ *
+ * This allows checking the exact sequence of events that the daemon generates.
+ */
+class RecordingEmitter : public apps::daemon::Emitter {
+public:
+	std::string name {};
+
+	// The keys and axes that were registered before the device was created.
+	mutable std::set<i32> keys {};
+	mutable std::set<u16> axes {};
+
+	// Whether the device was created.
+	mutable bool created = false;
+
+	// All events that were emitted since the last call to @ref clear.
+	std::vector<Event> events {};
+
+public:
+	void set_name(std::string value) override
+	{
+		name = std::move(value);
+	}
+
+	void set_vendor(const u16 /* unused */) override {};
+	void set_product(const u16 /* unused */) override {};
+	void set_version(const u16 /* unused */) override {};
+	void set_evbit(const i32 /* unused */) const override {};
+	void set_propbit(const i32 /* unused */) const override {};
+	void set_relbit(const i32 /* unused */) const override {};
+	void set_mscbit(const i32 /* unused */) const override {};
+
+	void set_keybit(const i32 key) const override
+	{
+		keys.insert(key);
+	}
+
+	void set_absinfo(const u16 code,
+	                 const i32 /* unused */,
+	                 const i32 /* unused */,
+	                 const i32 /* unused */) const override
+	{
+		axes.insert(code);
+	}
+
+	void create() const override
+	{
+		created = true;
+	}
+
+	void emit(const u16 type, const u16 key, const i32 value) override
+	{
+		events.push_back(Event {type, key, value});
+	}
+
+	/*!
+	 * Forgets all events that were recorded so far.
+	 */
+	void clear()
+	{
+		events.clear();
+	}
+};
+
+/*!
+ * Fails the current test if a sequence of events is different from the expected one.
+ *
+ * @param[in] actual The events that were emitted.
+ * @param[in] expected The events that should have been emitted, in the same order.
+ * @param[in] what A description of the events, for the error message.
+ */
+inline void expect_events(const std::vector<Event> &actual,
+                          const std::vector<Event> &expected,
+                          const std::string &what)
+{
+	if (actual == expected)
+		return;
+
+	const auto join = [](const std::vector<Event> &events) {
+		std::string text {};
+
+		for (const Event &event : events)
+			text += text.empty() ? format_as(event) : " " + format_as(event);
+
+		return text;
+	};
+
+	throw std::runtime_error {
+		fmt::format("{}: expected {}, got {}", what, join(expected), join(actual)),
+	};
+}
+
+} // namespace iptsd::tests
+
+#endif // IPTSD_TESTS_RECORDING_EMITTER_HPP
@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#include "test.hpp"
+
+#include <apps/daemon/socket-emitter.hpp>
+#include <common/types.hpp>
+
+#include <linux/input-event-codes.h>
+
+#include <chrono>
+#include <cstddef>
+#include <string>
+#include <sys/socket.h>
+#include <sys/un.h>
+#include <thread>
+#include <unistd.h>
+
+namespace iptsd::tests {
+namespace {
+
+using apps::daemon::SocketEmitter;
+
+/*
+ * A unix socket that the emitter connects to, standing in for the service that injects
+ * the events.
+ */
+class Server {
+public:
+	std::string path;
+
+	// The listening socket.
+	int fd = -1;
+
+	// The connection to the emitter, once it was accepted.
+	int client = -1;
+
+	// Whether the emitter closed the connection.
+	mutable bool closed = false;
+
+public:
+	Server()
+	{
+		path = "@iptsd-test-" + std::to_string(::getpid());
+		fd = ::socket(AF_UNIX, SOCK_STREAM, 0);
+
+		struct sockaddr_un addr {};
+		addr.sun_family = AF_UNIX;
+
+		// NOLINTNEXTLINE(cppcoreguidelines-pro-bounds-array-to-pointer-decay)
+		const usize length = path.copy(addr.sun_path, sizeof(addr.sun_path) - 1, 0);
+		addr.sun_path[0] = '\0';
+
+		const usize offset = offsetof(struct sockaddr_un, sun_path);
+		const auto size = static_cast<socklen_t>(offset + length);
+
+		// NOLINTNEXTLINE(cppcoreguidelines-pro-type-reinterpret-cast)
+		const auto *address = reinterpret_cast<const struct sockaddr *>(&addr);
+
+		expect(::bind(fd, address, size) == 0, "failed to bind the socket");
+		expect(::listen(fd, 4) == 0, "failed to listen on the socket");
+	}
+
+	~Server()
+	{
+		this->hang_up();
+		::close(fd);
+	}
+
+	Server(const Server &) = delete;
+	Server &operator=(const Server &) = delete;
+
+	/*!
+	 * Accepts the next connection from an emitter.
+	 */
+	void accept()
+	{
+		client = ::accept4(fd, nullptr, nullptr, SOCK_NONBLOCK);
+		expect(client != -1, "the emitter didn't connect");
+	}
+
+	/*!
+	 * Closes the connection to the emitter.
+	 */
+	void hang_up()
+	{
+		if (client != -1)
+			::close(client);
+
+		client = -1;
+	}
+
+	/*!
+	 * Reads everything that the emitter sent so far.
+	 *
+	 * @return The text that was received.
+	 */
+	[[nodiscard]] std::string read() const
+	{
+		std::string text {};
+		std::string buffer(4096, '\0');
+
+		while (true) {
+			const isize ret = ::read(client, buffer.data(), buffer.size());
+
+			if (ret == 0)
+				closed = true;
+
+			if (ret <= 0)
+				break;
+
+			text.append(buffer.data(), static_cast<usize>(ret));
+		}
+
+		return text;
+	}
+};
+
+/*!
+ * Describes a device with a single key.
+ *
+ * @param[in] emitter The emitter to describe the device to.
+ */
+void describe(SocketEmitter &emitter)
+{
+	emitter.set_name("Test");
+	emitter.set_vendor(1);
+	emitter.set_product(2);
+	emitter.set_version(3);
+	emitter.set_evbit(EV_KEY);
+	emitter.set_keybit(BTN_TOUCH);
+	emitter.create();
+}
+
+/*!
+ * Emits a frame that presses the key.
+ *
+ * @param[in] emitter The emitter that sends the frame.
+ */
+void press(SocketEmitter &emitter)
+{
+	emitter.emit(EV_KEY, BTN_TOUCH, 1);
+	emitter.emit(EV_SYN, SYN_REPORT, 0);
+}
+
+const std::string DESCRIPTION = "evbit 1\nkeybit 330\nid 1 2 3\ncreate Test\n";
+const std::string FRAME = "event 1 330 1\nevent 0 0 0\n";
+
+void description_and_frame()
+{
+	Server server {};
+	SocketEmitter emitter {server.path};
+	server.accept();
+
+	describe(emitter);
+	press(emitter);
+
+	expect_eq(server.read(), DESCRIPTION + FRAME, "received text");
+}
+
+void peer_not_reading()
+{
+	Server server {};
+	SocketEmitter emitter {server.path};
+	server.accept();
+
+	describe(emitter);
+
+	const auto start = std::chrono::steady_clock::now();
+
+	// Far more than the socket can buffer. None of this may block.
+	for (usize i = 0; i < 100000; i++)
+		press(emitter);
+
+	const auto elapsed = std::chrono::steady_clock::now() - start;
+	expect(elapsed < std::chrono::seconds {5}, "sending blocked while the peer didn't read");
+
+	// Frames are dropped as a whole. A frame is only cut off when the emitter disconnects.
+	const std::string text = server.read();
+	const bool whole = (text.size() - DESCRIPTION.size()) % FRAME.size() == 0;
+
+	expect(text.rfind(DESCRIPTION, 0) == 0, "the description was not sent first");
+	expect(whole || server.closed, "a partial frame was sent");
+}
+
+void reconnect()
+{
+	Server server {};
+	SocketEmitter emitter {server.path};
+	server.accept();
+
+	describe(emitter);
+	server.hang_up();
+
+	// The lost connection must not throw into the caller.
+	press(emitter);
+	press(emitter);
+
+	std::this_thread::sleep_for(std::chrono::milliseconds {1100});
+
+	// The next frame connects again, and describes the device before sending it.
+	press(emitter);
+	server.accept();
+
+	expect_eq(server.read(), DESCRIPTION + FRAME, "received text after reconnecting");
+}
+
+} // namespace
+} // namespace iptsd::tests
+
+int main()
+{
+	using namespace iptsd::tests;
+
+	return run({
+		{"description_and_frame", description_and_frame},
+		{"peer_not_reading", peer_not_reading},
+		{"reconnect", reconnect},
+	});
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#ifndef IPTSD_TESTS_TEST_HPP
+#define IPTSD_TESTS_TEST_HPP
+
+#include <common/types.hpp>
+
+#include <fmt/format.h>
+#include <spdlog/spdlog.h>
+
+#include <cstdlib>
+#include <exception>
+#include <functional>
+#include <stdexcept>
+#include <string>
+#include <utility>
+#include <vector>
+
+namespace iptsd::tests {
+
+/*
+ * A named test case. It fails by throwing an exception.
+ */
+using Test = std::pair<std::string, std::function<void()>>;
+
+/*!
+ * Fails the current test if a condition is not met.
+ *
+ * @param[in] condition The condition that must be true.
+ * @param[in] what A description of the condition, for the error message.
+ */
+inline void expect(const bool condition, const std::string &what)
+{
+	if (!condition)
+		throw std::runtime_error {what};
+}
+
+/*!
+ * Fails the current test if a value is different from the expected one.
+ *
+ * @param[in] actual The value that was produced.
+ * @param[in] expected The value that should have been produced.
+ * @param[in] what A description of the value, for the error message.
+ */
+template <class T>
+void expect_eq(const T &actual, const T &expected, const std::string &what)
+{
+	if (actual == expected)
+		return;
+
+	throw std::runtime_error {fmt::format("{}: expected {}, got {}", what, expected, actual)};
+}
+
+/*!
+ * Runs a list of tests and reports which of them failed.
+ *
+ * @param[in] tests The tests to run.
+ * @return The exit code of the test program.
+ */
+inline int run(const std::vector<Test> &tests)
+{
+	spdlog::set_pattern("[%^%l%$] %v");
+
+	usize failed = 0;
+
+	for (const auto &[name, test] : tests) {
+		try {
+			test();
+			spdlog::info("PASS {}", name);
+		} catch (const std::exception &e) {
+			spdlog::error("FAIL {}: {}", name, e.what());
+			failed++;
+		}
+	}
+
+	spdlog::info("{} of {} tests passed", tests.size() - failed, tests.size());
+	return failed == 0 ? EXIT_SUCCESS : EXIT_FAILURE;
+}
+
+} // namespace iptsd::tests
+
+#endif // IPTSD_TESTS_TEST_HPP
@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#include "buffers.hpp"
+#include "recording-emitter.hpp"
+#include "test.hpp"
+
+#include <apps/daemon/stylus.hpp>
+#include <common/types.hpp>
+#include <core/generic/application.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+#include <ipts/data.hpp>
+#include <ipts/protocol/report.hpp>
+#include <ipts/protocol/stylus.hpp>
+
+#include <gsl/gsl>
+
+#include <linux/input-event-codes.h>
+
+#include <memory>
+#include <optional>
+#include <string>
+#include <utility>
+#include <vector>
+
+namespace iptsd::tests {
+namespace {
+
+namespace report = ipts::protocol::report;
+namespace stylus = ipts::protocol::stylus;
+
+using apps::daemon::StylusDevice;
+
+/*
+ * Passes the corrected stylus data of the application on to a stylus device.
+ */
+class Harness : public core::Application {
+public:
+	std::shared_ptr<RecordingEmitter> emitter = std::make_shared<RecordingEmitter>();
+
+private:
+	StylusDevice m_stylus;
+
+public:
+	explicit Harness(const core::Config &config)
+		: core::Application(config, core::DeviceInfo {}, std::nullopt),
+		  m_stylus {config, core::DeviceInfo {}, emitter}
+	{
+	}
+
+	void on_stylus(const ipts::StylusData &data) override
+	{
+		m_stylus.update(data);
+	}
+};
+
+/*!
+ * The configuration of a 26 x 17 cm screen, with everything else left at the defaults.
+ */
+core::Config screen()
+{
+	core::Config config {};
+	config.width = 26;
+	config.height = 17;
+
+	return config;
+}
+
+/*!
+ * Sends a single stylus sample with a known tilt through the application.
+ *
+ * The stylus touches the display and is tilted by 45 degrees.
+ *
+ * @param[in] config The configuration of the orientation that is tested.
+ * @param[in] azimuth The direction of the tilt, in centidegrees.
+ * @return The tilt that was emitted on the X and Y axis.
+ */
+std::pair<i32, i32> tilt(const core::Config &config, const u16 azimuth)
+{
+	stylus::SampleMPP_1_51 sample {};
+	sample.state.proximity = true;
+	sample.state.contact = true;
+	sample.x = 4800;
+	sample.y = 3600;
+	sample.pressure = 2048;
+	sample.altitude = 4500;
+	sample.azimuth = azimuth;
+
+	Harness harness {config};
+
+	auto buffer = stylus_buffer(report::Type::StylusMPP_1_51, 1, std::vector {sample});
+	harness.process(gsl::span<u8> {buffer});
+
+	std::optional<i32> x = std::nullopt;
+	std::optional<i32> y = std::nullopt;
+
+	for (const Event &event : harness.emitter->events) {
+		if (event.type == EV_ABS && event.code == ABS_TILT_X)
+			x = event.value;
+
+		if (event.type == EV_ABS && event.code == ABS_TILT_Y)
+			y = event.value;
+	}
+
+	expect(x.has_value() && y.has_value(), "no tilt was emitted");
+	return {x.value(), y.value()};
+}
+
+/*!
+ * Fails the current test if the emitted tilt points into the wrong direction.
+ *
+ * @param[in] config The configuration of the orientation that is tested.
+ * @param[in] azimuth The direction of the tilt reported by the stylus, in centidegrees.
+ * @param[in] expected The tilt that should be emitted on the X and Y axis.
+ * @param[in] what A description of the orientation, for the error message.
+ */
+void expect_tilt(const core::Config &config,
+                 const u16 azimuth,
+                 const std::pair<i32, i32> &expected,
+                 const std::string &what)
+{
+	const auto [x, y] = tilt(config, azimuth);
+
+	expect_eq(x, expected.first, what + ": tilt on the X axis");
+	expect_eq(y, expected.second, what + ": tilt on the Y axis");
+}
+
+// A tilt of 45 degrees, in the units of the tilt axes.
+constexpr i32 TILT = 4500;
+
+void unchanged()
+{
+	// An azimuth of 0 tilts the stylus towards positive X, 90 degrees towards negative Y.
+	expect_tilt(screen(), 0, {TILT, 0}, "azimuth 0");
+	expect_tilt(screen(), 9000, {0, -TILT}, "azimuth 90");
+}
+
+void rotation()
+{
+	// The screen is rotated clockwise, and the tilt has to follow it.
+	const std::vector<std::pair<u16, std::pair<i32, i32>>> rotations {
+		{0, {TILT, 0}},
+		{90, {0, TILT}},
+		{180, {-TILT, 0}},
+		{270, {0, -TILT}},
+	};
+
+	for (const auto &[degrees, expected] : rotations) {
+		core::Config config = screen();
+		config.rotation = degrees;
+
+		expect_tilt(config, 0, expected, "rotation " + std::to_string(degrees));
+	}
+}
+
+void invert_x()
+{
+	core::Config config = screen();
+	config.stylus_invert_x = true;
+
+	expect_tilt(config, 0, {-TILT, 0}, "InvertX");
+	expect_tilt(config, 9000, {0, -TILT}, "InvertX");
+}
+
+void invert_y()
+{
+	core::Config config = screen();
+	config.stylus_invert_y = true;
+
+	expect_tilt(config, 0, {TILT, 0}, "InvertY");
+	expect_tilt(config, 9000, {0, TILT}, "InvertY");
+}
+
+void swap_xy()
+{
+	core::Config config = screen();
+	config.swap_xy = true;
+
+	// Transposing exchanges the tilt axes, without changing their sign.
+	expect_tilt(config, 0, {0, TILT}, "SwapXY");
+	expect_tilt(config, 9000, {-TILT, 0}, "SwapXY");
+}
+
+} // namespace
+} // namespace iptsd::tests
+
+int main()
+{
+	using namespace iptsd::tests;
+
+	return run({
+		{"unchanged", unchanged},
+		{"rotation", rotation},
+		{"invert_x", invert_x},
+		{"invert_y", invert_y},
+		{"swap_xy", swap_xy},
+	});
+}
@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#include "recording-emitter.hpp"
+#include "test.hpp"
+
+#include <apps/daemon/touch.hpp>
+#include <common/types.hpp>
+#include <contacts/contact.hpp>
+#include <contacts/tracking/tracker.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+
+#include <linux/input-event-codes.h>
+
+#include <memory>
+#include <vector>
+
+namespace iptsd::tests {
+namespace {
+
+using apps::daemon::TouchDevice;
+using contacts::tracking::Tracker;
+
+/*!
+ * A finger at a position of the display, that wasn't tracked yet.
+ *
+ * @param[in] x The normalized position on the X axis.
+ * @param[in] y The normalized position on the Y axis.
+ */
+contacts::Contact<f64> finger(const f64 x, const f64 y)
+{
+	contacts::Contact<f64> contact {};
+	contact.mean = Vector2<f64> {x, y};
+	contact.size = Vector2<f64> {0.1, 0.1};
+	contact.normalized = true;
+	contact.valid = true;
+	contact.stable = true;
+
+	return contact;
+}
+
+/*!
+ * The tracking IDs that a touchscreen emitted, in order.
+ *
+ * @param[in] emitter The emitter of the touchscreen.
+ * @return The values of all ABS_MT_TRACKING_ID events.
+ */
+std::vector<i32> tracking_ids(const RecordingEmitter &emitter)
+{
+	std::vector<i32> ids {};
+
+	for (const Event &event : emitter.events) {
+		if (event.type == EV_ABS && event.code == ABS_MT_TRACKING_ID)
+			ids.push_back(event.value);
+	}
+
+	return ids;
+}
+
+void nearest_contact()
+{
+	Tracker<f64> tracker {};
+
+	std::vector<contacts::Contact<f64>> first {finger(0.2, 0.2), finger(0.8, 0.8)};
+	tracker.track(first);
+
+	expect_eq(first[0].index.value(), usize {0}, "index of the first finger");
+	expect_eq(first[1].index.value(), usize {1}, "index of the second finger");
+
+	// Both fingers moved a bit, and the detector found them in the opposite order.
+	std::vector<contacts::Contact<f64>> second {finger(0.75, 0.8), finger(0.25, 0.2)};
+	tracker.track(second);
+
+	expect_eq(second[0].index.value(), usize {1}, "index of the moved second finger");
+	expect_eq(second[1].index.value(), usize {0}, "index of the moved first finger");
+}
+
+void new_finger_index()
+{
+	Tracker<f64> tracker {};
+
+	std::vector<contacts::Contact<f64>> first {finger(0.2, 0.2), finger(0.8, 0.8)};
+	tracker.track(first);
+
+	// The first finger is lifted.
+	std::vector<contacts::Contact<f64>> second {finger(0.8, 0.8)};
+	tracker.track(second);
+
+	expect_eq(second[0].index.value(), usize {1}, "index of the remaining finger");
+
+	std::vector<contacts::Contact<f64>> third {finger(0.8, 0.8), finger(0.2, 0.2)};
+	tracker.track(third);
+
+	// A new finger never gets an index that a finger of the last frame had.
+	expect_eq(third[0].index.value(), usize {1}, "index of the remaining finger");
+	expect_eq(third[1].index.value(), usize {2}, "index of the new finger");
+}
+
+void tracking_id_reuse()
+{
+	core::Config config {};
+	config.width = 26;
+	config.height = 17;
+
+	const auto emitter = std::make_shared<RecordingEmitter>();
+	TouchDevice touch {config, core::DeviceInfo {}, emitter};
+
+	Tracker<f64> tracker {};
+
+	std::vector<contacts::Contact<f64>> frame {finger(0.2, 0.2), finger(0.8, 0.8)};
+	tracker.track(frame);
+	touch.update(frame);
+
+	expect(tracking_ids(*emitter) == std::vector<i32> {0, 1}, "IDs of the first frame");
+	emitter->clear();
+
+	// The first finger is lifted, the second one keeps its ID.
+	frame = {finger(0.8, 0.8)};
+	tracker.track(frame);
+	touch.update(frame);
+
+	expect(tracking_ids(*emitter) == std::vector<i32> {1, -1}, "IDs after lifting");
+	emitter->clear();
+
+	// A new finger gets an ID that is not used by the remaining finger.
+	frame = {finger(0.8, 0.8), finger(0.2, 0.2)};
+	tracker.track(frame);
+	touch.update(frame);
+
+	expect(tracking_ids(*emitter) == std::vector<i32> {1, 2}, "IDs after a new finger");
+}
+
+} // namespace
+} // namespace iptsd::tests
+
+int main()
+{
+	using namespace iptsd::tests;
+
+	return run({
+		{"nearest_contact", nearest_contact},
+		{"new_finger_index", new_finger_index},
+		{"tracking_id_reuse", tracking_id_reuse},
+	});
+}
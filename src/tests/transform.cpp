@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#include "buffers.hpp"
+#include "test.hpp"
+
+#include <common/types.hpp>
+#include <contacts/contact.hpp>
+#include <core/generic/application.hpp>
+#include <core/generic/config.hpp>
+#include <core/generic/device.hpp>
+#include <ipts/data.hpp>
+#include <ipts/protocol/report.hpp>
+#include <ipts/protocol/stylus.hpp>
+
+#include <gsl/gsl>
+
+#include <cmath>
+#include <optional>
+#include <string>
+#include <vector>
+
+namespace iptsd::tests {
+namespace {
+
+namespace report = ipts::protocol::report;
+namespace stylus = ipts::protocol::stylus;
+
+/*
+ * Records where the application places the stylus and the contacts on the screen.
+ */
+class Harness : public core::Application {
+public:
+	std::optional<Vector2<f64>> stylus = std::nullopt;
+
+public:
+	explicit Harness(const core::Config &config)
+		: core::Application(config, core::DeviceInfo {}, std::nullopt)
+	{
+	}
+
+	/*!
+	 * Places a contact that was found at a position of the heatmap.
+	 *
+	 * @param[in] position The position of the contact, in the space of the digitizer.
+	 * @return The position of the contact on the screen.
+	 */
+	Vector2<f64> touch(const Vector2<f64> &position) const
+	{
+		contacts::Contact<f64> contact {};
+		contact.mean = position;
+		contact.normalized = true;
+
+		std::vector<contacts::Contact<f64>> contacts {contact};
+		this->place_contacts(contacts);
+
+		return contacts.front().mean;
+	}
+
+	void on_stylus(const ipts::StylusData &data) override
+	{
+		stylus = Vector2<f64> {data.x, data.y};
+	}
+};
+
+/*!
+ * The configuration of a digitizer with mirrored axes, that is misaligned by a tenth of its
+ * width to the right and a twentieth of its height to the bottom.
+ */
+core::Config misaligned()
+{
+	core::Config config {};
+	config.width = 26;
+	config.height = 17;
+	config.invert_x = true;
+	config.invert_y = true;
+	config.transform = {1, 0, 0.1, 0, 1, 0.05};
+
+	return config;
+}
+
+/*!
+ * Places a stylus and a finger at the same spot of the digitizer.
+ *
+ * IPTS reports the stylus already mirrored by InvertX and InvertY, while the contacts are
+ * found in the unmirrored heatmap.
+ *
+ * @param[in] config The configuration that is tested.
+ * @param[in] position The spot that is touched, in the space of the digitizer.
+ * @param[in] what A description of the spot, for the error message.
+ */
+void expect_same_spot(const core::Config &config,
+                      const Vector2<f64> &position,
+                      const std::string &what)
+{
+	const f64 x = config.invert_x ? 1.0 - position.x() : position.x();
+	const f64 y = config.invert_y ? 1.0 - position.y() : position.y();
+
+	stylus::SampleMPP_1_0 sample {};
+	sample.state.proximity = true;
+	sample.x = static_cast<u16>(std::lround(x * 9600));
+	sample.y = static_cast<u16>(std::lround(y * 7200));
+
+	Harness harness {config};
+
+	auto buffer = stylus_buffer(report::Type::StylusMPP_1_0, 1, std::vector {sample});
+	harness.process(gsl::span<u8> {buffer});
+
+	expect(harness.stylus.has_value(), "no stylus data was processed");
+
+	const Vector2<f64> touch = harness.touch(position);
+	const Vector2<f64> pen = harness.stylus.value();
+
+	expect(std::abs(touch.x() - pen.x()) < 1e-3, what + ": stylus and touch differ on X");
+	expect(std::abs(touch.y() - pen.y()) < 1e-3, what + ": stylus and touch differ on Y");
+}
+
+void touch_and_stylus()
+{
+	const core::Config config = misaligned();
+
+	expect_same_spot(config, Vector2<f64> {0.25, 0.5}, "left");
+	expect_same_spot(config, Vector2<f64> {0.5, 0.25}, "top");
+	expect_same_spot(config, Vector2<f64> {0.75, 0.75}, "bottom right");
+}
+
+void direction()
+{
+	const core::Config config = misaligned();
+	const Harness harness {config};
+
+	// The transform moves to the right on the digitizer, which is to the left on the screen.
+	const Vector2<f64> moved = harness.touch(Vector2<f64> {0.25, 0.5});
+
+	expect(std::abs(moved.x() - 0.65) < 1e-9, "the contact was moved the wrong way on X");
+	expect(std::abs(moved.y() - 0.45) < 1e-9, "the contact was moved the wrong way on Y");
+}
+
+void rotated()
+{
+	core::Config config = misaligned();
+	config.swap_xy = true;
+	config.rotation = 90;
+
+	expect_same_spot(config, Vector2<f64> {0.25, 0.5}, "SwapXY and rotation");
+}
+
+} // namespace
+} // namespace iptsd::tests
+
+int main()
+{
+	using namespace iptsd::tests;
+
+	return run({
+		{"touch_and_stylus", touch_and_stylus},
+		{"direction", direction},
+		{"rotated", rotated},
+	});
+}
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: GPL-2.0-or-later
+
+#include "recording-emitter.hpp"
+#include "test.hpp"
+
+#include <apps/daemon/uinput-device.hpp>
+#include <common/casts.hpp>
+#include <common/types.hpp>
+#include <core/linux/syscalls.hpp>
+
+#include <gsl/gsl>
+
+#include <linux/input.h>
+
+#include <array>
+#include <fcntl.h>
+#include <poll.h>
+#include <unistd.h>
+#include <vector>
+
+namespace syscalls = iptsd::core::linux::syscalls;
+
+namespace iptsd::tests {
+namespace {
+
+using apps::daemon::UinputDevice;
+
+/*
+ * A pipe that stands in for the uinput node. The device writes to one end and takes
+ * ownership of it, the test reads whatever arrived from the other one.
+ */
+class Pipe {
+public:
+	int read_fd = -1;
+	int write_fd = -1;
+
+public:
+	Pipe()
+	{
+		std::array<int, 2> fds {};
+
+		expect(::pipe2(fds.data(), O_NONBLOCK) == 0, "failed to create a pipe");
+
+		read_fd = fds[0];
+		write_fd = fds[1];
+	}
+
+	~Pipe()
+	{
+		::close(read_fd);
+	}
+
+	Pipe(const Pipe &) = delete;
+	Pipe &operator=(const Pipe &) = delete;
+
+	/*!
+	 * Checks whether any data has been written to the pipe.
+	 *
+	 * @return Whether there is data that can be read without blocking.
+	 */
+	[[nodiscard]] bool readable() const
+	{
+		struct pollfd fd {};
+
+		fd.fd = read_fd;
+		fd.events = POLLIN;
+
+		return syscalls::poll(fd, 0) > 0;
+	}
+
+	/*!
+	 * Reads the input events that are currently in the pipe, using a single read.
+	 *
+	 * @return The events, in the order in which they were written.
+	 */
+	[[nodiscard]] std::vector<Event> read() const
+	{
+		std::vector<struct input_event> buffer(64);
+
+		const gsl::span<struct input_event> dest {buffer.data(), buffer.size()};
+		const isize size = syscalls::read(read_fd, dest);
+		const usize count = casts::to<usize>(size) / sizeof(struct input_event);
+
+		expect(casts::to<usize>(size) % sizeof(struct input_event) == 0,
+		       "a partial event was written");
+
+		std::vector<Event> events {};
+
+		for (usize i = 0; i < count; i++) {
+			const struct input_event &ie = buffer[i];
+			events.push_back(Event {ie.type, ie.code, ie.value});
+		}
+
+		return events;
+	}
+};
+
+/*!
+ * Emits a sequence of events, in the order in which they are listed.
+ *
+ * @param[in] device The device that receives the events.
+ * @param[in] events The events to emit.
+ */
+void emit(UinputDevice &device, const std::vector<Event> &events)
+{
+	for (const Event &event : events)
+		device.emit(event.type, event.code, event.value);
+}
+
+void batched_until_report()
+{
+	const Pipe pipe {};
+	UinputDevice device {pipe.write_fd};
+
+	const std::vector<Event> frame {
+		{EV_KEY, BTN_TOUCH, 1},
+		{EV_KEY, BTN_TOOL_PEN, 1},
+		{EV_ABS, ABS_X, 4800},
+		{EV_ABS, ABS_Y, 1800},
+		{EV_ABS, ABS_PRESSURE, 2048},
+	};
+
+	emit(device, frame);
+	expect(!pipe.readable(), "events were written before the SYN_REPORT");
+
+	device.emit(EV_SYN, SYN_REPORT, 0);
+
+	std::vector<Event> expected = frame;
+	expected.push_back(Event {EV_SYN, SYN_REPORT, 0});
+
+	// The whole frame arrives at once, in the order in which it was emitted.
+	expect_events(pipe.read(), expected, "events of a batched frame");
+	expect(!pipe.readable(), "more events were written than emitted");
+}
+
+void frames_are_separate()
+{
+	const Pipe pipe {};
+	UinputDevice device {pipe.write_fd};
+
+	const std::vector<Event> first {
+		{EV_ABS, ABS_X, 100},
+		{EV_SYN, SYN_REPORT, 0},
+	};
+
+	const std::vector<Event> second {
+		{EV_ABS, ABS_X, 200},
+		{EV_ABS, ABS_Y, 300},
+		{EV_SYN, SYN_REPORT, 0},
+	};
+
+	emit(device, first);
+	expect_events(pipe.read(), first, "events of the first frame");
+
+	// Events of the previous frame must not be written again.
+	emit(device, second);
+	expect_events(pipe.read(), second, "events of the second frame");
+}
+
+void other_sync_events()
+{
+	const Pipe pipe {};
+	UinputDevice device {pipe.write_fd};
+
+	// Only SYN_REPORT finishes a frame.
+	device.emit(EV_ABS, ABS_X, 100);
+	device.emit(EV_SYN, SYN_MT_REPORT, 0);
+
+	expect(!pipe.readable(), "events were written before the SYN_REPORT");
+}
+
+} // namespace
+} // namespace iptsd::tests
+
+int main()
+{
+	using namespace iptsd::tests;
+
+	return run({
+		{"batched_until_report", batched_until_report},
+		{"frames_are_separate", frames_are_separate},
+		{"other_sync_events", other_sync_events},
+	});
+}